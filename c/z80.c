@@ -17,9 +17,22 @@ void cpu_init(cpu *z) {
   z->rom = (void *)0;
   z->xrom = (void *)0;
   z->xram = (void *)0;
+  z->watch = (void *)0;
+  z->watch_len = 0;
+  z->irq_vector = 0;
+  z->undo_addr = (void *)0;
+  z->undo_old = (void *)0;
+  z->undo_len = 0;
+  z->undo_cap = 0;
+  z->cdl_rom = (void *)0;
+  z->cdl_xrom = (void *)0;
+  z->cdl_ram = (void *)0;
+  z->genie_len = 0;
+  z->shark_len = 0;
 }
 
-u8 cpu_read(cpu *z, u16 addr) {
+// reads memory without triggering watchpoints
+u8 cpu_peek(cpu *z, u16 addr) {
   if (addr >= 0xfe00) {
     return z->hram[addr-0xfe00];
   }
@@ -36,6 +49,66 @@ u8 cpu_read(cpu *z, u16 addr) {
   return 0;
 }
 
+static void cdl_mark(cpu *z, u16 addr, u8 flag) {
+  if (!z->cdl_rom) {
+    return;
+  }
+  if (addr < 0x4000) {
+    z->cdl_rom[addr] |= flag;
+  } else if (addr < 0x8000) {
+    z->cdl_xrom[addr-0x4000] |= flag;
+  } else if (addr >= 0xe000 && addr < 0xfe00) {
+    z->cdl_ram[addr-0xa000] |= flag;
+  } else {
+    z->cdl_ram[addr-0x8000] |= flag;
+  }
+}
+
+static u8 genie_read(cpu *z, u16 addr, u8 byte) {
+  for (u8 i = 0; i < z->genie_len && addr < 0x8000; i++) {
+    genie_code *g = &z->genie[i];
+    if (g->addr == addr && (!g->has_compare || g->compare == byte)) {
+      return g->value;
+    }
+  }
+  return byte;
+}
+
+static void watch_record(cpu *z, u8 kind, u16 addr, u8 old, u8 value) {
+  if (z->watch_len < WATCH_HITS_MAX) {
+    watch_hit *h = &z->watch_hits[z->watch_len++];
+    h->kind = kind;
+    h->addr = addr;
+    h->old = old;
+    h->value = value;
+  }
+}
+
+u8 cpu_read(cpu *z, u16 addr) {
+  cdl_mark(z, addr, CDL_READ);
+  u8 byte = genie_read(z, addr, cpu_peek(z, addr));
+  if (z->watch && (z->watch[addr] & WATCH_READ)) {
+    watch_record(z, WATCH_READ, addr, byte, byte);
+  }
+  return byte;
+}
+
+// reads the byte at pc as part of the current instruction, which doesn't
+// trigger read watchpoints
+static u8 cpu_fetch(cpu *z, u8 flag) {
+  cdl_mark(z, z->pc, flag);
+  u16 addr = z->pc++;
+  return genie_read(z, addr, cpu_peek(z, addr));
+}
+
+// skips the operands of a branch that isn't taken, logging them as part of
+// the instruction all the same
+static void cpu_skip(cpu *z, u8 n) {
+  for (u8 i = 0; i < n; i++) {
+    cdl_mark(z, z->pc++, CDL_OPERAND);
+  }
+}
+
 // TODO: this only partially emulates MBC1
 static void cpu_bank_select(cpu *z) {
   u8 shift = (z->rom ? z->rom[0x0148] : 0);
@@ -61,7 +134,19 @@ static void cpu_bank_select(cpu *z) {
   }
 }
 
-void cpu_write(cpu *z, u16 addr, u8 byte) {
+// writes memory without triggering watchpoints
+static void mem_write(cpu *z, u16 addr, u8 byte) {
+  // mbc registers are restored with the rest of the cpu state
+  if (z->undo_addr && addr >= 0x8000 && ((addr>>13) != 5 || (z->xram && z->xram_enabled))) {
+    if (z->undo_len < z->undo_cap) {
+      z->undo_addr[z->undo_len] = addr;
+      z->undo_old[z->undo_len] = cpu_peek(z, addr);
+    }
+    if (z->undo_len < 0xff) {
+      z->undo_len++;
+    }
+  }
+
   if (addr >= 0xfe00) {
     z->hram[addr-0xfe00] = byte;
     return;
@@ -78,6 +163,19 @@ void cpu_write(cpu *z, u16 addr, u8 byte) {
   }
 }
 
+void cpu_write(cpu *z, u16 addr, u8 byte) {
+  if (addr >= 0x8000) {
+    cdl_mark(z, addr, CDL_WRITE);
+  }
+  if (z->watch && z->watch[addr]) {
+    u8 old = cpu_peek(z, addr);
+    if ((z->watch[addr] & WATCH_WRITE) || ((z->watch[addr] & WATCH_CHANGE) && old != byte)) {
+      watch_record(z, WATCH_WRITE, addr, old, byte);
+    }
+  }
+  mem_write(z, addr, byte);
+}
+
 #define NN(l,h) ((u16)((l)+(((u16)(h))<<8)))
 #define HL(z) NN((z)->l,(z)->h)
 #define BC(z) NN((z)->c,(z)->b)
@@ -94,14 +192,14 @@ INLINE u16 set_nhc_flags(cpu *z, u16 a, u16 b, u16 res) {
   return res;
 }
 
-INLINE void ld_b_n(cpu *z) { z->b = cpu_read(z, z->pc++); z->cycles += 2; }
-INLINE void ld_c_n(cpu *z) { z->c = cpu_read(z, z->pc++); z->cycles += 2; }
-INLINE void ld_d_n(cpu *z) { z->d = cpu_read(z, z->pc++); z->cycles += 2; }
-INLINE void ld_e_n(cpu *z) { z->e = cpu_read(z, z->pc++); z->cycles += 2; }
-INLINE void ld_h_n(cpu *z) { z->h = cpu_read(z, z->pc++); z->cycles += 2; }
-INLINE void ld_l_n(cpu *z) { z->l = cpu_read(z, z->pc++); z->cycles += 2; }
-INLINE void ld_hl_n(cpu *z) { cpu_write(z, HL(z), cpu_read(z, z->pc++)); z->cycles += 3; }
-INLINE void ld_a_n(cpu *z) { z->a = cpu_read(z, z->pc++); z->cycles += 2; }
+INLINE void ld_b_n(cpu *z) { z->b = cpu_fetch(z, CDL_OPERAND); z->cycles += 2; }
+INLINE void ld_c_n(cpu *z) { z->c = cpu_fetch(z, CDL_OPERAND); z->cycles += 2; }
+INLINE void ld_d_n(cpu *z) { z->d = cpu_fetch(z, CDL_OPERAND); z->cycles += 2; }
+INLINE void ld_e_n(cpu *z) { z->e = cpu_fetch(z, CDL_OPERAND); z->cycles += 2; }
+INLINE void ld_h_n(cpu *z) { z->h = cpu_fetch(z, CDL_OPERAND); z->cycles += 2; }
+INLINE void ld_l_n(cpu *z) { z->l = cpu_fetch(z, CDL_OPERAND); z->cycles += 2; }
+INLINE void ld_hl_n(cpu *z) { cpu_write(z, HL(z), cpu_fetch(z, CDL_OPERAND)); z->cycles += 3; }
+INLINE void ld_a_n(cpu *z) { z->a = cpu_fetch(z, CDL_OPERAND); z->cycles += 2; }
 INLINE void ld_b_b(cpu *z) { z->b = z->b; z->cycles += 1; }
 INLINE void ld_b_c(cpu *z) { z->b = z->c; z->cycles += 1; }
 INLINE void ld_b_d(cpu *z) { z->b = z->d; z->cycles += 1; }
@@ -169,31 +267,31 @@ INLINE void ld_bc_a(cpu *z) { cpu_write(z, BC(z), z->a); z->cycles += 2; }
 INLINE void ld_a_bc(cpu *z) { z->a = cpu_read(z, BC(z)); z->cycles += 2; }
 INLINE void ld_de_a(cpu *z) { cpu_write(z, DE(z), z->a); z->cycles += 2; }
 INLINE void ld_a_de(cpu *z) { z->a = cpu_read(z, DE(z)); z->cycles += 2; }
-INLINE void ld_a_nn(cpu *z) { u8 l = cpu_read(z, z->pc++); u8 h = cpu_read(z, z->pc++); z->a = cpu_read(z, NN(l, h)); z->cycles += 4; }
-INLINE void ld_nn_a(cpu *z) { u8 l = cpu_read(z, z->pc++); u8 h = cpu_read(z, z->pc++); cpu_write(z, NN(l, h), z->a); z->cycles += 4; }
+INLINE void ld_a_nn(cpu *z) { u8 l = cpu_fetch(z, CDL_OPERAND); u8 h = cpu_fetch(z, CDL_OPERAND); z->a = cpu_read(z, NN(l, h)); z->cycles += 4; }
+INLINE void ld_nn_a(cpu *z) { u8 l = cpu_fetch(z, CDL_OPERAND); u8 h = cpu_fetch(z, CDL_OPERAND); cpu_write(z, NN(l, h), z->a); z->cycles += 4; }
 INLINE void ldh_c_a(cpu *z) { cpu_write(z, NN(z->c, 0xff), z->a); z->cycles += 2; }
 INLINE void ldh_a_c(cpu *z) { z->a = cpu_read(z, NN(z->c, 0xff)); z->cycles += 2; }
-INLINE void ldh_n_a(cpu *z) { cpu_write(z, NN(cpu_read(z, z->pc++), 0xff), z->a); z->cycles += 3; }
-INLINE void ldh_a_n(cpu *z) { z->a = cpu_read(z, NN(cpu_read(z, z->pc++), 0xff)); z->cycles += 3; }
+INLINE void ldh_n_a(cpu *z) { cpu_write(z, NN(cpu_fetch(z, CDL_OPERAND), 0xff), z->a); z->cycles += 3; }
+INLINE void ldh_a_n(cpu *z) { z->a = cpu_read(z, NN(cpu_fetch(z, CDL_OPERAND), 0xff)); z->cycles += 3; }
 INLINE void ldi_hl_a(cpu *z) { cpu_write(z, HL(z), z->a); if (!(++z->l)) z->h++; z->cycles += 2; }
 INLINE void ldi_a_hl(cpu *z) { z->a = cpu_read(z, HL(z)); if (!(++z->l)) z->h++; z->cycles += 2; }
 INLINE void ldd_hl_a(cpu *z) { cpu_write(z, HL(z), z->a); if (!(z->l--)) z->h--; z->cycles += 2; }
 INLINE void ldd_a_hl(cpu *z) { z->a = cpu_read(z, HL(z)); if (!(z->l--)) z->h--; z->cycles += 2; }
-INLINE void ld_bc_nn(cpu *z) { z->c = cpu_read(z, z->pc++); z->b = cpu_read(z, z->pc++); z->cycles += 3; }
-INLINE void ld_de_nn(cpu *z) { z->e = cpu_read(z, z->pc++); z->d = cpu_read(z, z->pc++); z->cycles += 3; }
-INLINE void ld_hl_nn(cpu *z) { z->l = cpu_read(z, z->pc++); z->h = cpu_read(z, z->pc++); z->cycles += 3; }
-INLINE void ld_sp_nn(cpu *z) { u8 l = cpu_read(z, z->pc++); u8 h = cpu_read(z, z->pc++); z->sp = NN(l, h); z->cycles += 3; }
+INLINE void ld_bc_nn(cpu *z) { z->c = cpu_fetch(z, CDL_OPERAND); z->b = cpu_fetch(z, CDL_OPERAND); z->cycles += 3; }
+INLINE void ld_de_nn(cpu *z) { z->e = cpu_fetch(z, CDL_OPERAND); z->d = cpu_fetch(z, CDL_OPERAND); z->cycles += 3; }
+INLINE void ld_hl_nn(cpu *z) { z->l = cpu_fetch(z, CDL_OPERAND); z->h = cpu_fetch(z, CDL_OPERAND); z->cycles += 3; }
+INLINE void ld_sp_nn(cpu *z) { u8 l = cpu_fetch(z, CDL_OPERAND); u8 h = cpu_fetch(z, CDL_OPERAND); z->sp = NN(l, h); z->cycles += 3; }
 INLINE void ld_sp_hl(cpu *z) { z->sp = NN(z->l, z->h); z->cycles += 2; }
 INLINE void ldhl_sp_n(cpu *z) {
-  s8 n = (s8)cpu_read(z, z->pc++);
+  s8 n = (s8)cpu_fetch(z, CDL_OPERAND);
   u16 t = set_hc_flags(z, z->sp, (u16)n, (u16)(z->sp+n));
   z->l = (u8)t;
   z->h = (u8)(t >> 8);
   z->cycles += 3;
 }
 INLINE void ld_nn_sp(cpu *z) {
-  u8 l = cpu_read(z, z->pc++);
-  u8 h = cpu_read(z, z->pc++);
+  u8 l = cpu_fetch(z, CDL_OPERAND);
+  u8 h = cpu_fetch(z, CDL_OPERAND);
   u16 p = NN(l, h);
   cpu_write(z, p, (u8)z->sp);
   cpu_write(z, p+1, (u8)(z->sp>>8));
@@ -217,7 +315,7 @@ INLINE void add_a_h(cpu *z) { add_a(z, z->h); }
 INLINE void add_a_l(cpu *z) { add_a(z, z->l); }
 INLINE void add_a_hl(cpu *z) { add_a(z, cpu_read(z, HL(z))); z->cycles += 1; }
 INLINE void add_a_a(cpu *z) { add_a(z, z->a); }
-INLINE void add_a_n(cpu *z) { add_a(z, cpu_read(z, z->pc++)); z->cycles += 1; }
+INLINE void add_a_n(cpu *z) { add_a(z, cpu_fetch(z, CDL_OPERAND)); z->cycles += 1; }
 INLINE void adc_a(cpu *z, u8 x) { if ((z->a = (u8)set_hc_flags(z, z->a, x, z->a+x+((z->f&FLAG_C)>>4))) == 0) z->f |= FLAG_Z; z->cycles += 1; }
 INLINE void adc_a_b(cpu *z) { adc_a(z, z->b); }
 INLINE void adc_a_c(cpu *z) { adc_a(z, z->c); }
@@ -227,7 +325,7 @@ INLINE void adc_a_h(cpu *z) { adc_a(z, z->h); }
 INLINE void adc_a_l(cpu *z) { adc_a(z, z->l); }
 INLINE void adc_a_hl(cpu *z) { adc_a(z, cpu_read(z, HL(z))); z->cycles += 1; }
 INLINE void adc_a_a(cpu *z) { adc_a(z, z->a); }
-INLINE void adc_a_n(cpu *z) { adc_a(z, cpu_read(z, z->pc++)); z->cycles += 1; }
+INLINE void adc_a_n(cpu *z) { adc_a(z, cpu_fetch(z, CDL_OPERAND)); z->cycles += 1; }
 INLINE void sub_a(cpu *z, u8 x) { if ((z->a = (u8)set_nhc_flags(z, z->a, x, z->a-x)) == 0) z->f |= FLAG_Z; z->cycles += 1; }
 INLINE void sub_a_b(cpu *z) { sub_a(z, z->b); }
 INLINE void sub_a_c(cpu *z) { sub_a(z, z->c); }
@@ -237,7 +335,7 @@ INLINE void sub_a_h(cpu *z) { sub_a(z, z->h); }
 INLINE void sub_a_l(cpu *z) { sub_a(z, z->l); }
 INLINE void sub_a_hl(cpu *z) { sub_a(z, cpu_read(z, HL(z))); z->cycles += 1; }
 INLINE void sub_a_a(cpu *z) { sub_a(z, z->a); }
-INLINE void sub_a_n(cpu *z) { sub_a(z, cpu_read(z, z->pc++)); z->cycles += 1; }
+INLINE void sub_a_n(cpu *z) { sub_a(z, cpu_fetch(z, CDL_OPERAND)); z->cycles += 1; }
 INLINE void sbc_a(cpu *z, u8 x) { if ((z->a = (u8)set_nhc_flags(z, z->a, x, z->a-x-((z->f&FLAG_C)>>4))) == 0) z->f |= FLAG_Z; z->cycles += 1; }
 INLINE void sbc_a_b(cpu *z) { sbc_a(z, z->b); }
 INLINE void sbc_a_c(cpu *z) { sbc_a(z, z->c); }
@@ -247,7 +345,7 @@ INLINE void sbc_a_h(cpu *z) { sbc_a(z, z->h); }
 INLINE void sbc_a_l(cpu *z) { sbc_a(z, z->l); }
 INLINE void sbc_a_hl(cpu *z) { sbc_a(z, cpu_read(z, HL(z))); z->cycles += 1; }
 INLINE void sbc_a_a(cpu *z) { sbc_a(z, z->a); }
-INLINE void sbc_a_n(cpu *z) { sbc_a(z, cpu_read(z, z->pc++)); z->cycles += 1; }
+INLINE void sbc_a_n(cpu *z) { sbc_a(z, cpu_fetch(z, CDL_OPERAND)); z->cycles += 1; }
 INLINE void and_a_b(cpu *z) { z->f = (z->a &= z->b) ? FLAG_H : (FLAG_Z | FLAG_H); z->cycles += 1; }
 INLINE void and_a_c(cpu *z) { z->f = (z->a &= z->c) ? FLAG_H : (FLAG_Z | FLAG_H); z->cycles += 1; }
 INLINE void and_a_d(cpu *z) { z->f = (z->a &= z->d) ? FLAG_H : (FLAG_Z | FLAG_H); z->cycles += 1; }
@@ -256,7 +354,7 @@ INLINE void and_a_h(cpu *z) { z->f = (z->a &= z->h) ? FLAG_H : (FLAG_Z | FLAG_H)
 INLINE void and_a_l(cpu *z) { z->f = (z->a &= z->l) ? FLAG_H : (FLAG_Z | FLAG_H); z->cycles += 1; }
 INLINE void and_a_hl(cpu *z) { z->f = (z->a &= cpu_read(z, HL(z))) ? FLAG_H : (FLAG_Z | FLAG_H); z->cycles += 2; }
 INLINE void and_a_a(cpu *z) { z->f = (z->a &= z->a) ? FLAG_H : (FLAG_Z | FLAG_H); z->cycles += 1; }
-INLINE void and_a_n(cpu *z) { z->f = (z->a &= cpu_read(z, z->pc++)) ? FLAG_H : (FLAG_Z | FLAG_H); z->cycles += 2; }
+INLINE void and_a_n(cpu *z) { z->f = (z->a &= cpu_fetch(z, CDL_OPERAND)) ? FLAG_H : (FLAG_Z | FLAG_H); z->cycles += 2; }
 INLINE void xor_a_b(cpu *z) { z->f = (z->a ^= z->b) ? 0 : FLAG_Z; z->cycles += 1; }
 INLINE void xor_a_c(cpu *z) { z->f = (z->a ^= z->c) ? 0 : FLAG_Z; z->cycles += 1; }
 INLINE void xor_a_d(cpu *z) { z->f = (z->a ^= z->d) ? 0 : FLAG_Z; z->cycles += 1; }
@@ -265,7 +363,7 @@ INLINE void xor_a_h(cpu *z) { z->f = (z->a ^= z->h) ? 0 : FLAG_Z; z->cycles += 1
 INLINE void xor_a_l(cpu *z) { z->f = (z->a ^= z->l) ? 0 : FLAG_Z; z->cycles += 1; }
 INLINE void xor_a_hl(cpu *z) { z->f = (z->a ^= cpu_read(z, HL(z))) ? 0 : FLAG_Z; z->cycles += 2; }
 INLINE void xor_a_a(cpu *z) { z->f = (z->a ^= z->a) ? 0 : FLAG_Z; z->cycles += 1; }
-INLINE void xor_a_n(cpu *z) { z->f = (z->a ^= cpu_read(z, z->pc++)) ? 0 : FLAG_Z; z->cycles += 2; }
+INLINE void xor_a_n(cpu *z) { z->f = (z->a ^= cpu_fetch(z, CDL_OPERAND)) ? 0 : FLAG_Z; z->cycles += 2; }
 INLINE void or_a_b(cpu *z) { z->f = (z->a |= z->b) ? 0 : FLAG_Z; z->cycles += 1; }
 INLINE void or_a_c(cpu *z) { z->f = (z->a |= z->c) ? 0 : FLAG_Z; z->cycles += 1; }
 INLINE void or_a_d(cpu *z) { z->f = (z->a |= z->d) ? 0 : FLAG_Z; z->cycles += 1; }
@@ -274,7 +372,7 @@ INLINE void or_a_h(cpu *z) { z->f = (z->a |= z->h) ? 0 : FLAG_Z; z->cycles += 1;
 INLINE void or_a_l(cpu *z) { z->f = (z->a |= z->l) ? 0 : FLAG_Z; z->cycles += 1; }
 INLINE void or_a_hl(cpu *z) { z->f = (z->a |= cpu_read(z, HL(z))) ? 0 : FLAG_Z; z->cycles += 2; }
 INLINE void or_a_a(cpu *z) { z->f = (z->a |= z->a) ? 0 : FLAG_Z; z->cycles += 1; }
-INLINE void or_a_n(cpu *z) { z->f = (z->a |= cpu_read(z, z->pc++)) ? 0 : FLAG_Z; z->cycles += 2; }
+INLINE void or_a_n(cpu *z) { z->f = (z->a |= cpu_fetch(z, CDL_OPERAND)) ? 0 : FLAG_Z; z->cycles += 2; }
 INLINE void cp_a(cpu *z, u8 x) { if (set_nhc_flags(z, z->a, x, z->a-x) == 0) z->f |= FLAG_Z; z->cycles += 1; }
 INLINE void cp_a_b(cpu *z) { cp_a(z, z->b); }
 INLINE void cp_a_c(cpu *z) { cp_a(z, z->c); }
@@ -284,7 +382,7 @@ INLINE void cp_a_h(cpu *z) { cp_a(z, z->h); }
 INLINE void cp_a_l(cpu *z) { cp_a(z, z->l); }
 INLINE void cp_a_hl(cpu *z) { cp_a(z, cpu_read(z, HL(z))); z->cycles += 1; }
 INLINE void cp_a_a(cpu *z) { cp_a(z, z->a); }
-INLINE void cp_a_n(cpu *z) { cp_a(z, cpu_read(z, z->pc++)); z->cycles += 1; }
+INLINE void cp_a_n(cpu *z) { cp_a(z, cpu_fetch(z, CDL_OPERAND)); z->cycles += 1; }
 INLINE void _inc(cpu *z, u8 *r) { (*r)++; z->f = (z->f&FLAG_C) | ((*r)?0:FLAG_Z) | (((*r)&0x0f)?0:FLAG_H); }
 INLINE void inc_b(cpu *z) { _inc(z, &z->b); z->cycles += 1; }
 INLINE void inc_c(cpu *z) { _inc(z, &z->c); z->cycles += 1; }
@@ -326,7 +424,7 @@ INLINE void add_hl_de(cpu *z) { add_hl(z, ((u32)(z->d)<<8)+z->e); }
 INLINE void add_hl_hl(cpu *z) { add_hl(z, ((u32)(z->h)<<8)+z->l); }
 INLINE void add_hl_sp(cpu *z) { add_hl(z, z->sp); }
 INLINE void add_sp_n(cpu *z) {
-  s8 n = (s8)cpu_read(z, z->pc++);
+  s8 n = (s8)cpu_fetch(z, CDL_OPERAND);
   z->sp = set_hc_flags(z, z->sp, (u16)n, (u16)(z->sp+n));
   z->cycles += 4;
 }
@@ -363,32 +461,32 @@ INLINE void rlca(cpu *z) { z->f = (z->a>>3)&FLAG_C; z->a = (u8)(z->a<<1)|(z->a>>
 INLINE void rla(cpu *z) { u8 t = (z->f&FLAG_C)>>4; z->f = (z->a>>3)&FLAG_C; z->a = (u8)(z->a<<1)|t; z->cycles += 1; }
 INLINE void rrca(cpu *z) { z->f = (z->a<<4)&FLAG_C; z->a = (u8)(z->a<<7)|(z->a>>1); z->cycles += 1; }
 INLINE void rra(cpu *z) { u8 t = (u8)((z->f&FLAG_C)<<3); z->f = (z->a<<4)&FLAG_C; z->a = t|(z->a>>1); z->cycles += 1; }
-INLINE void _jp(cpu *z) { u8 l = cpu_read(z, z->pc++); u8 h = cpu_read(z, z->pc++); z->pc = NN(l, h); z->cycles += 1; }
+INLINE void _jp(cpu *z) { u8 l = cpu_fetch(z, CDL_OPERAND); u8 h = cpu_fetch(z, CDL_OPERAND); z->pc = NN(l, h); z->cycles += 1; }
 INLINE void jp(cpu *z) { _jp(z); z->cycles += 3; }
-INLINE void jp_nz(cpu *z) { if (!(z->f & FLAG_Z)) { _jp(z); } else { z->pc += 2; } z->cycles += 3; }
-INLINE void jp_z(cpu *z) { if (z->f & FLAG_Z) { _jp(z); } else { z->pc += 2; } z->cycles += 3; }
-INLINE void jp_nc(cpu *z) { if (!(z->f & FLAG_C)) { _jp(z); } else { z->pc += 2; } z->cycles += 3; }
-INLINE void jp_c(cpu *z) { if (z->f & FLAG_C) { _jp(z); } else { z->pc += 2; } z->cycles += 3; }
+INLINE void jp_nz(cpu *z) { if (!(z->f & FLAG_Z)) { _jp(z); } else { cpu_skip(z, 2); } z->cycles += 3; }
+INLINE void jp_z(cpu *z) { if (z->f & FLAG_Z) { _jp(z); } else { cpu_skip(z, 2); } z->cycles += 3; }
+INLINE void jp_nc(cpu *z) { if (!(z->f & FLAG_C)) { _jp(z); } else { cpu_skip(z, 2); } z->cycles += 3; }
+INLINE void jp_c(cpu *z) { if (z->f & FLAG_C) { _jp(z); } else { cpu_skip(z, 2); } z->cycles += 3; }
 INLINE void jp_hl(cpu *z) { z->pc = HL(z); z->cycles += 1; }
-INLINE void _jr(cpu *z) { z->pc += (s8)cpu_read(z, z->pc++); z->cycles += 1; }
+INLINE void _jr(cpu *z) { z->pc += (s8)cpu_fetch(z, CDL_OPERAND); z->cycles += 1; }
 INLINE void jr(cpu *z) { _jr(z); z->cycles += 2; }
-INLINE void jr_nz(cpu *z) { if (!(z->f & FLAG_Z)) { _jr(z); } else { z->pc += 1; } z->cycles += 2; }
-INLINE void jr_z(cpu *z) { if (z->f & FLAG_Z) { _jr(z); } else { z->pc += 1; } z->cycles += 2; }
-INLINE void jr_nc(cpu *z) { if (!(z->f & FLAG_C)) { _jr(z); } else { z->pc += 1; } z->cycles += 2; }
-INLINE void jr_c(cpu *z) { if (z->f & FLAG_C) { _jr(z); } else { z->pc += 1; } z->cycles += 2; }
+INLINE void jr_nz(cpu *z) { if (!(z->f & FLAG_Z)) { _jr(z); } else { cpu_skip(z, 1); } z->cycles += 2; }
+INLINE void jr_z(cpu *z) { if (z->f & FLAG_Z) { _jr(z); } else { cpu_skip(z, 1); } z->cycles += 2; }
+INLINE void jr_nc(cpu *z) { if (!(z->f & FLAG_C)) { _jr(z); } else { cpu_skip(z, 1); } z->cycles += 2; }
+INLINE void jr_c(cpu *z) { if (z->f & FLAG_C) { _jr(z); } else { cpu_skip(z, 1); } z->cycles += 2; }
 INLINE void _call(cpu *z) {
-  u8 l = cpu_read(z, z->pc++);
-  u8 h = cpu_read(z, z->pc++);
+  u8 l = cpu_fetch(z, CDL_OPERAND);
+  u8 h = cpu_fetch(z, CDL_OPERAND);
   cpu_write(z, --z->sp, (u8)(z->pc>>8));
   cpu_write(z, --z->sp, (u8)z->pc);
   z->pc = NN(l, h);
   z->cycles += 3;
 }
 INLINE void call(cpu *z) { _call(z); z->cycles += 3; }
-INLINE void call_nz(cpu *z) { if (!(z->f & FLAG_Z)) { _call(z); } else { z->pc += 2; } z->cycles += 3; }
-INLINE void call_z(cpu *z) { if (z->f & FLAG_Z) { _call(z); } else { z->pc += 2; } z->cycles += 3; }
-INLINE void call_nc(cpu *z) { if (!(z->f & FLAG_C)) { _call(z); } else { z->pc += 2; } z->cycles += 3; }
-INLINE void call_c(cpu *z) { if (z->f & FLAG_C) { _call(z); } else { z->pc += 2; } z->cycles += 3; }
+INLINE void call_nz(cpu *z) { if (!(z->f & FLAG_Z)) { _call(z); } else { cpu_skip(z, 2); } z->cycles += 3; }
+INLINE void call_z(cpu *z) { if (z->f & FLAG_Z) { _call(z); } else { cpu_skip(z, 2); } z->cycles += 3; }
+INLINE void call_nc(cpu *z) { if (!(z->f & FLAG_C)) { _call(z); } else { cpu_skip(z, 2); } z->cycles += 3; }
+INLINE void call_c(cpu *z) { if (z->f & FLAG_C) { _call(z); } else { cpu_skip(z, 2); } z->cycles += 3; }
 INLINE void rst(cpu *z, u16 n) { cpu_write(z, --z->sp, (u8)(z->pc>>8)); cpu_write(z, --z->sp, (u8)z->pc); z->pc = n; z->cycles += 4; }
 INLINE void _ret(cpu *z) { u8 l = cpu_read(z, z->sp++); u8 h = cpu_read(z, z->sp++); z->pc = NN(l, h); z->cycles += 3; }
 INLINE void ret(cpu *z) { _ret(z); z->cycles += 1; }
@@ -555,7 +653,7 @@ INLINE void set_hl(cpu *z, u8 b) { cpu_write(z, HL(z), cpu_read(z, HL(z)) | (u8)
 INLINE void set_a(cpu *z, u8 b) { _set(&z->a, b); z->cycles += 2; }
 
 INLINE void cb(cpu *z) {
-  u8 ins = cpu_read(z, z->pc++);
+  u8 ins = cpu_fetch(z, CDL_OPERAND);
 
   switch (ins) {
     case 0x00: rlc_b(z); break;
@@ -818,7 +916,7 @@ INLINE void cb(cpu *z) {
 }
 
 static void cpu_execute(cpu *z) {
-  u8 ins = cpu_read(z, z->pc++);
+  u8 ins = cpu_fetch(z, CDL_EXEC);
 
   switch (ins) {
     case 0x00: nop(z); break;
@@ -1086,7 +1184,7 @@ static void cpu_run_timers(cpu *z) {
   // DIV: 2^14 (16384) Hz = every 2^6 cycles
   // TODO this only works if <64 cycles have elapsed since last call
   if (z->cycles_prev % 64 > z->cycles % 64) {
-    cpu_write(z, REG_DIV, cpu_read(z, REG_DIV) + 1);
+    mem_write(z, REG_DIV, cpu_peek(z, REG_DIV) + 1);
   }
 
   // TIMA: check TAC b2==1 (timer enable), then check TAC b1b0
@@ -1094,7 +1192,7 @@ static void cpu_run_timers(cpu *z) {
   // 01: 2^18 (262144) Hz = every 2^2 cycles
   // 10: 2^16 (65536) Hz = every 2^4 cycles
   // 11: 2^14 (16384) Hz = every 2^6 cycles
-  u8 tac = cpu_read(z, REG_TAC);
+  u8 tac = cpu_peek(z, REG_TAC);
   if (tac & TAC_ENABLE) {
     u32 divisors[4] = {1<<7, 1<<1, 1<<3, 1<<5};
     u32 divisor = divisors[tac & TAC_CLOCK_SELECT];
@@ -1106,12 +1204,12 @@ static void cpu_run_timers(cpu *z) {
       u32 this = (z->cycles_prev & divisor);
       // timers tick on falling edge
       if (last && !this) {
-        u8 tima = cpu_read(z, REG_TIMA) + 1;
+        u8 tima = cpu_peek(z, REG_TIMA) + 1;
         if (tima == 0) {
-          cpu_write(z, REG_TIMA, cpu_read(z, REG_TMA));
-          cpu_write(z, REG_IF, cpu_read(z, REG_IF)|INT_TIMER);
+          mem_write(z, REG_TIMA, cpu_peek(z, REG_TMA));
+          mem_write(z, REG_IF, cpu_peek(z, REG_IF)|INT_TIMER);
         } else {
-          cpu_write(z, REG_TIMA, tima);
+          mem_write(z, REG_TIMA, tima);
         }
       }
       last = this;
@@ -1122,7 +1220,7 @@ static void cpu_run_timers(cpu *z) {
 }
 
 static u8 cpu_handle_irqs(cpu *z) {
-  u8 masked = cpu_read(z, REG_IF) & cpu_read(z, REG_IE);
+  u8 masked = cpu_peek(z, REG_IF) & cpu_peek(z, REG_IE);
   if (masked) {
     // always take cpu out of halted even if interrupts are disabled
     z->halted = 0;
@@ -1130,22 +1228,23 @@ static u8 cpu_handle_irqs(cpu *z) {
     if (z->irq_enabled) {
       cpu_write(z, --z->sp, (u8)(z->pc>>8)); cpu_write(z, --z->sp, (u8)z->pc);
       if (masked & INT_VBLANK) {
-        cpu_write(z, REG_IF, cpu_read(z, REG_IF)&~INT_VBLANK);
+        mem_write(z, REG_IF, cpu_peek(z, REG_IF)&~INT_VBLANK);
         z->pc = 0x0040;
       } else if (masked & INT_LCDC_STAT) {
-        cpu_write(z, REG_IF, cpu_read(z, REG_IF)&~INT_LCDC_STAT);
+        mem_write(z, REG_IF, cpu_peek(z, REG_IF)&~INT_LCDC_STAT);
         z->pc = 0x0048;
       } else if (masked & INT_TIMER) {
-        cpu_write(z, REG_IF, cpu_read(z, REG_IF)&~INT_TIMER);
+        mem_write(z, REG_IF, cpu_peek(z, REG_IF)&~INT_TIMER);
         z->pc = 0x0050;
       } else if (masked & INT_SERIAL) {
-        cpu_write(z, REG_IF, cpu_read(z, REG_IF)&~INT_SERIAL);
+        mem_write(z, REG_IF, cpu_peek(z, REG_IF)&~INT_SERIAL);
         z->pc = 0x0058;
       } else if (masked & INT_BUTTON) {
-        cpu_write(z, REG_IF, cpu_read(z, REG_IF)&~INT_BUTTON);
+        mem_write(z, REG_IF, cpu_peek(z, REG_IF)&~INT_BUTTON);
         z->pc = 0x0060;
       }
 
+      z->irq_vector = z->pc;
       z->irq_enabled = 0;
       // https://gbdev.gg8.se/wiki/articles/Interrupts#Interrupt_Service_Routine
       z->cycles += 5;
@@ -1155,7 +1254,20 @@ static u8 cpu_handle_irqs(cpu *z) {
   return 0;
 }
 
+static void cpu_apply_sharks(cpu *z) {
+  for (u8 i = 0; i < z->shark_len; i++) {
+    shark_code *g = &z->shark[i];
+    if (!g->has_bank || (g->addr>>13) != 5 || g->bank == z->xram_bank) {
+      mem_write(z, g->addr, g->value);
+    }
+  }
+}
+
 void cpu_step(cpu *z) {
+  // a gameshark writes its codes at vblank, the start of a frame will do
+  if (z->shark_len && z->cycles / FRAME_CYCLES != z->cycles_prev / FRAME_CYCLES) {
+    cpu_apply_sharks(z);
+  }
   cpu_run_timers(z);
   if (cpu_handle_irqs(z)) {
     return;
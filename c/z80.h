@@ -8,6 +8,29 @@
 #define FLAG_H (1<<5)
 #define FLAG_C (1<<4)
 
+#define FRAME_CYCLES (17556) // machine cycles per frame
+#define CHEATS_MAX   (32)
+#define WATCH_HITS_MAX (4) // more data accesses than any instruction makes
+
+// replaces the byte read from rom at addr, if it was compare
+typedef struct {
+  u16 addr;
+  u8 value, compare, has_compare;
+} genie_code;
+
+// writes value to ram at addr once a frame, in bank if it is external ram
+typedef struct {
+  u16 addr;
+  u8 value, bank, has_bank;
+} shark_code;
+
+// an access to a watched address
+typedef struct {
+  u16 addr;
+  u8 kind; // WATCH_READ or WATCH_WRITE
+  u8 old, value;
+} watch_hit;
+
 typedef struct {
   u8 b, c, d, e, h, l, a, f;
   u16 sp, pc;
@@ -26,15 +49,49 @@ typedef struct {
   u8 cart_reg1, cart_reg2, cart_reg3;
   u8 xrom_bank, xram_bank;
   u8 xram_enabled;
+
+  // watchpoints
+  u8 *watch;       // WATCH_* flags per address, null when nothing is watched
+  watch_hit watch_hits[WATCH_HITS_MAX]; // in order of access, opcode fetches excluded
+  u8 watch_len;    // cleared by caller
+
+  // interrupts
+  u16 irq_vector;  // vector of the last dispatched interrupt, cleared by caller
+
+  // undo log of ram writes, null when not rewinding
+  u16 *undo_addr;
+  u8 *undo_old;
+  u8 undo_len;     // may exceed undo_cap if the log overflowed, reset by caller
+  u8 undo_cap;
+
+  // code/data log, null when not logging
+  u8 *cdl_rom;     // CDL_* flags for 0x0000-0x3fff
+  u8 *cdl_xrom;    // 0x4000-0x7fff in the current bank
+  u8 *cdl_ram;     // 0x8000-0xffff, echo ram is logged as 0xc000-0xddff
+
+  // cheats, the first genie_len and shark_len are active
+  genie_code genie[CHEATS_MAX];
+  shark_code shark[CHEATS_MAX];
+  u8 genie_len, shark_len;
 } cpu;
 
 void cpu_init(cpu *z);
 void cpu_step(cpu *z);
 u8 cpu_read(cpu *z, u16 addr);
+u8 cpu_peek(cpu *z, u16 addr);
 void cpu_write(cpu *z, u16 addr, u8 byte);
 
 #define XRAM_ENABLE (0x0a)
 
+#define WATCH_READ   (1<<0)
+#define WATCH_WRITE  (1<<1)
+#define WATCH_CHANGE (1<<2)
+
+#define CDL_EXEC    (1<<0) // first byte of an executed instruction
+#define CDL_OPERAND (1<<1) // remaining bytes of an executed instruction
+#define CDL_READ    (1<<2)
+#define CDL_WRITE   (1<<3)
+
 #define REG_DIV  (0xff04)
 #define REG_TIMA (0xff05)
 #define REG_TMA  (0xff06)
@@ -3,7 +3,11 @@ module github.com/echojc/goboy2/go
 go 1.22.6
 
 require (
-	fyne.io/fyne/v2 v2.5.0 // indirect
+	fyne.io/fyne/v2 v2.5.0
+	github.com/jroimartin/gocui v0.5.0
+)
+
+require (
 	fyne.io/systray v1.11.0 // indirect
 	github.com/BurntSushi/toml v1.4.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -19,7 +23,6 @@ require (
 	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/gopherjs/gopherjs v1.17.2 // indirect
 	github.com/jeandeaual/go-locale v0.0.0-20240223122105-ce5225dcaa49 // indirect
-	github.com/jroimartin/gocui v0.5.0 // indirect
 	github.com/jsummers/gobmp v0.0.0-20151104160322-e2ba15ffa76e // indirect
 	github.com/mattn/go-runewidth v0.0.9 // indirect
 	github.com/nicksnyder/go-i18n/v2 v2.4.0 // indirect
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Assemble assembles one instruction at addr. It accepts the RGBDS syntax
+// as well as what Decode produces, so (hl) and [hl], ld [hl+], a and
+// ldi (hl), a are all fine. Numbers are decimal unless prefixed with $ or
+// 0x (hex) or % (binary). Names are looked up with symbol, and @ is addr.
+func Assemble(addr uint16, src string, symbol func(name string) (uint16, bool)) ([]byte, error) {
+	mnemonic, rest, _ := strings.Cut(strings.TrimSpace(src), " ")
+	mnemonic = strings.ToLower(mnemonic)
+	var args []asmOperand
+	if rest = strings.TrimSpace(rest); rest != "" {
+		for _, s := range strings.Split(rest, ",") {
+			args = append(args, parseAsmOperand(s))
+		}
+	}
+	mnemonic, args = canonicalize(mnemonic, args)
+
+	a := assembler{addr: addr, symbol: symbol}
+	var lastErr error
+	for i := 0; i < 512; i++ {
+		op := tern(i < 256, &opcodes[i&0xff], &cbOpcodes[i&0xff])
+		if op.mnemonic != mnemonic {
+			continue
+		}
+		bytes, err := a.encode(i, op, args)
+		if err == nil {
+			return bytes, nil
+		}
+		if lastErr == nil || err != errAsmMismatch {
+			lastErr = err
+		}
+	}
+	if lastErr == nil || lastErr == errAsmMismatch {
+		return nil, fmt.Errorf("no such instruction %q", strings.TrimSpace(src))
+	}
+	return nil, lastErr
+}
+
+var errAsmMismatch = fmt.Errorf("operands do not match")
+
+type asmOperand struct {
+	mem  bool   // in brackets or parens
+	text string // without brackets or spaces
+	reg  string // text in lower case, to match registers and conditions
+}
+
+func parseAsmOperand(s string) asmOperand {
+	s = strings.ReplaceAll(strings.TrimSpace(s), " ", "")
+	mem := len(s) >= 2 && (s[0] == '[' && s[len(s)-1] == ']' || s[0] == '(' && s[len(s)-1] == ')')
+	if mem {
+		s = s[1 : len(s)-1]
+	}
+	return asmOperand{mem: mem, text: s, reg: strings.ToLower(s)}
+}
+
+var aluMnemonics = []string{"add", "adc", "sub", "sbc", "and", "xor", "or", "cp"}
+
+// canonicalize rewrites the aliases rgbasm accepts into the forms used by
+// the opcode tables.
+func canonicalize(mnemonic string, args []asmOperand) (string, []asmOperand) {
+	for i, arg := range args {
+		if !arg.mem {
+			continue
+		}
+		switch arg.reg {
+		case "hl+", "hli":
+			mnemonic, args[i].reg = "ldi", "hl"
+		case "hl-", "hld":
+			mnemonic, args[i].reg = "ldd", "hl"
+		case "$ff00+c", "0xff00+c", "c+$ff00":
+			args[i].reg = "c"
+		}
+		if args[i].reg == "c" {
+			mnemonic = tern(mnemonic == "ld", "ldh", mnemonic)
+		}
+	}
+
+	switch {
+	case mnemonic == "ld" && len(args) == 2 && args[0].reg == "hl" && !args[1].mem && strings.HasPrefix(args[1].reg, "sp"):
+		// ld hl, sp+n
+		return "ldhl", []asmOperand{{reg: "sp"}, {text: args[1].text[2:]}}
+	case mnemonic == "ldhl" && len(args) == 1 && strings.HasPrefix(args[0].reg, "sp"):
+		return "ldhl", []asmOperand{{reg: "sp"}, {text: args[0].text[2:]}}
+	case len(args) == 2 && args[0].reg == "a" && !args[0].mem && isALU(mnemonic):
+		// add a, b is add b
+		return mnemonic, args[1:]
+	}
+	return mnemonic, args
+}
+
+func isALU(m string) bool {
+	for _, a := range aluMnemonics {
+		if a == m {
+			return true
+		}
+	}
+	return false
+}
+
+type assembler struct {
+	addr   uint16
+	symbol func(string) (uint16, bool)
+}
+
+// encode assembles args as opcode i, where 256 and up are cb prefixed.
+func (a *assembler) encode(i int, op *opcode, args []asmOperand) ([]byte, error) {
+	if op.cond != "" {
+		if len(args) == 0 || args[0].mem || args[0].reg != op.cond {
+			return nil, errAsmMismatch
+		}
+		args = args[1:]
+	}
+	if len(args) != len(op.operands) {
+		return nil, errAsmMismatch
+	}
+
+	bytes := []byte{byte(i)}
+	if i >= 256 {
+		bytes = []byte{0xcb, byte(i)}
+	}
+	for j, t := range op.operands {
+		arg := args[j]
+		switch t.kind {
+		case OperandReg:
+			if arg.mem || arg.reg != t.reg {
+				return nil, errAsmMismatch
+			}
+			continue
+		case OperandIndirect:
+			if !arg.mem || arg.reg != t.reg {
+				return nil, errAsmMismatch
+			}
+			continue
+		case OperandAddr:
+			if !arg.mem || isRegister(arg.reg) {
+				return nil, errAsmMismatch
+			}
+		default:
+			if arg.mem || isRegister(arg.reg) {
+				return nil, errAsmMismatch
+			}
+		}
+
+		v, err := a.eval(arg.text)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case t.kind == OperandBit:
+			if v != t.value {
+				return nil, tern(v >= 0 && v <= 7, errAsmMismatch, fmt.Errorf("bit %d out of range", v))
+			}
+		case t.kind == OperandVector:
+			if v != t.value {
+				return nil, tern(v >= 0 && v <= 0x38 && v%8 == 0, errAsmMismatch, fmt.Errorf("rst $%x is not a vector", v))
+			}
+		case t.kind == OperandRel:
+			offset := v - int(a.addr+2)
+			if v < 0 || v > 0xffff || offset < -128 || offset > 127 {
+				return nil, fmt.Errorf("jr target $%04x out of range", uint16(v))
+			}
+			bytes = append(bytes, byte(offset))
+		case t.imm == immHigh:
+			if v >= 0xff00 && v <= 0xffff {
+				v -= 0xff00
+			}
+			if v < 0 || v > 0xff {
+				return nil, fmt.Errorf("ldh address $%04x not in $ff00-$ffff", v)
+			}
+			bytes = append(bytes, byte(v))
+		case t.imm == immSigned:
+			if v >= 0x80 && v <= 0xff {
+				v -= 0x100
+			}
+			if v < -128 || v > 127 {
+				return nil, fmt.Errorf("offset %d out of range", v)
+			}
+			bytes = append(bytes, byte(v))
+		case t.imm == imm8:
+			if v < -128 || v > 0xff {
+				return nil, fmt.Errorf("$%x does not fit in a byte", v)
+			}
+			bytes = append(bytes, byte(v))
+		case t.imm == imm16:
+			if v < -0x8000 || v > 0xffff {
+				return nil, fmt.Errorf("$%x does not fit in a word", v)
+			}
+			bytes = append(bytes, byte(v), byte(v>>8))
+		}
+	}
+	return bytes, nil
+}
+
+func isRegister(s string) bool {
+	switch s {
+	case "a", "b", "c", "d", "e", "h", "l", "af", "bc", "de", "hl", "sp":
+		return true
+	}
+	return false
+}
+
+// eval evaluates sums and differences of numbers and names.
+func (a *assembler) eval(s string) (int, error) {
+	if s == "" {
+		return 0, fmt.Errorf("missing operand")
+	}
+	total, sign := 0, 1
+	for len(s) > 0 {
+		switch s[0] {
+		case '+':
+			s = s[1:]
+			continue
+		case '-':
+			sign = -sign
+			s = s[1:]
+			continue
+		}
+		end := strings.IndexAny(s[1:], "+-") + 1
+		if end == 0 {
+			end = len(s)
+		}
+		v, err := a.term(s[:end])
+		if err != nil {
+			return 0, err
+		}
+		total += sign * v
+		s, sign = s[end:], 1
+	}
+	return total, nil
+}
+
+func (a *assembler) term(s string) (int, error) {
+	var v uint64
+	var err error
+	switch {
+	case s == "@":
+		return int(a.addr), nil
+	case strings.HasPrefix(s, "$"):
+		v, err = strconv.ParseUint(s[1:], 16, 16)
+	case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0X"):
+		v, err = strconv.ParseUint(s[2:], 16, 16)
+	case strings.HasPrefix(s, "%"):
+		v, err = strconv.ParseUint(s[1:], 2, 16)
+	case s[0] >= '0' && s[0] <= '9':
+		v, err = strconv.ParseUint(s, 10, 16)
+	default:
+		if a.symbol != nil {
+			if addr, ok := a.symbol(s); ok {
+				return int(addr), nil
+			}
+		}
+		return 0, fmt.Errorf("unknown symbol %q", s)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("bad number %q", s)
+	}
+	return int(v), nil
+}
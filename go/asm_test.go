@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Everything Decode produces assembles back to the same bytes.
+func TestAssembleDecoded(t *testing.T) {
+	for i := 0; i < 512; i++ {
+		code := []byte{byte(i), 0x34, 0x12}
+		if i >= 256 {
+			code = []byte{0xcb, byte(i)}
+		}
+		ins := Decode(0x0150, code)
+		if !ins.Valid() || i == 0xcb {
+			continue
+		}
+		got, err := Assemble(0x0150, ins.String(), nil)
+		if err != nil || !bytes.Equal(got, code[:ins.Length]) {
+			t.Errorf("%s: assembled to % x, %v, want % x", ins, got, err, code[:ins.Length])
+		}
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	symbols := map[string]uint16{"Main": 0x0150, "wLives": 0xc100, "rLCDC": 0xff40}
+	symbol := func(name string) (uint16, bool) { addr, ok := symbols[name]; return addr, ok }
+	tests := []struct {
+		src  string
+		want []byte
+	}{
+		{"nop", []byte{0x00}},
+		{"LD A, 5", []byte{0x3e, 0x05}},
+		{"ld a, $ff", []byte{0x3e, 0xff}},
+		{"ld a, 0x1F", []byte{0x3e, 0x1f}},
+		{"ld a, %1010", []byte{0x3e, 0x0a}},
+		{"ld bc, 1000", []byte{0x01, 0xe8, 0x03}},
+		{"ld a, [hl+]", []byte{0x2a}},
+		{"ld a, [hli]", []byte{0x2a}},
+		{"ldi a, (hl)", []byte{0x2a}},
+		{"ld [hl-], a", []byte{0x32}},
+		{"ld (hld), a", []byte{0x32}},
+		{"ld [c], a", []byte{0xe2}},
+		{"ldh [c], a", []byte{0xe2}},
+		{"ldh a, [rLCDC]", []byte{0xf0, 0x40}},
+		{"ld [wLives], a", []byte{0xea, 0x00, 0xc1}},
+		{"ld a, [wLives + 1]", []byte{0xfa, 0x01, 0xc1}},
+		{"ld hl, sp+5", []byte{0xf8, 0x05}},
+		{"ld hl, sp-2", []byte{0xf8, 0xfe}},
+		{"add sp, -2", []byte{0xe8, 0xfe}},
+		{"add a, b", []byte{0x80}},
+		{"add b", []byte{0x80}},
+		{"sub a, 1", []byte{0xd6, 0x01}},
+		{"jp Main", []byte{0xc3, 0x50, 0x01}},
+		{"jp hl", []byte{0xe9}},
+		{"jr @", []byte{0x18, 0xfe}},
+		{"jr nz, Main", []byte{0x20, 0xfe}},
+		{"jr z, @+2", []byte{0x28, 0x00}},
+		{"call c, $4000", []byte{0xdc, 0x00, 0x40}},
+		{"rst $38", []byte{0xff}},
+		{"bit 7, [hl]", []byte{0xcb, 0x7e}},
+		{"set 0, a", []byte{0xcb, 0xc7}},
+		{"swap e", []byte{0xcb, 0x33}},
+		{"stop", []byte{0x10}},
+	}
+	for _, tt := range tests {
+		got, err := Assemble(0x0150, tt.src, symbol)
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got % x, %v, want % x", tt.src, got, err, tt.want)
+		}
+	}
+
+	for _, src := range []string{
+		"foo",           // unknown mnemonic
+		"ld a, 256",     // out of range
+		"ld (bc), b",    // no such addressing mode
+		"jr $0300",      // too far
+		"ld a, [wMiss]", // unknown symbol
+		"rst $39",
+		"bit 8, a",
+		"ld sp, a",
+	} {
+		if got, err := Assemble(0x0150, src, symbol); err == nil {
+			t.Errorf("%s: assembled to % x", src, got)
+		}
+	}
+}
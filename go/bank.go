@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BankAddr is a bank-qualified address. Bank is only meaningful in the
+// switchable regions, 0x4000-0x7fff (rom) and 0xa000-0xbfff (cart ram), and is
+// always 0 elsewhere.
+type BankAddr struct {
+	Bank uint8
+	Addr uint16
+}
+
+func isBanked(addr uint16) bool {
+	return (addr >= 0x4000 && addr < 0x8000) || (addr >= 0xa000 && addr < 0xc000)
+}
+
+func (a BankAddr) String() string {
+	if isBanked(a.Addr) {
+		return fmt.Sprintf("%02X:%04X", a.Bank, a.Addr)
+	}
+	return fmt.Sprintf("%04X", a.Addr)
+}
+
+func (a BankAddr) Compare(b BankAddr) int {
+	if a.Bank != b.Bank {
+		return int(a.Bank) - int(b.Bank)
+	}
+	return int(a.Addr) - int(b.Addr)
+}
+
+// BankAddr qualifies addr with the bank that is currently mapped there.
+func (d *Debugger) BankAddr(addr uint16) BankAddr {
+	switch {
+	case addr >= 0x4000 && addr < 0x8000:
+		return BankAddr{Bank: uint8(d.romBank), Addr: addr}
+	case addr >= 0xa000 && addr < 0xc000:
+		return BankAddr{Bank: uint8(d.Z.CPU.xram_bank), Addr: addr}
+	}
+	return BankAddr{Addr: addr}
+}
+
+// Offset adds n to a, keeping the bank as long as the result stays within
+// the same switchable region.
+func (d *Debugger) Offset(a BankAddr, n int) BankAddr {
+	addr := a.Addr + uint16(n)
+	// 0x4000-0x7fff and 0xa000-0xbfff are in different 16k pages
+	if isBanked(a.Addr) && isBanked(addr) && a.Addr>>14 == addr>>14 {
+		return BankAddr{Bank: a.Bank, Addr: addr}
+	}
+	return d.BankAddr(addr)
+}
+
+// ReadBank reads from rom banks directly so unmapped banks can be
+// inspected. Everything else is read through the current mapping.
+func (d *Debugger) ReadBank(a BankAddr) byte {
+	if a.Addr >= 0x4000 && a.Addr < 0x8000 && int(a.Bank) < len(d.romBanks) {
+		return byte(asSlice(d.romBanks[a.Bank], 0x4000)[a.Addr-0x4000])
+	}
+	return d.Read(a.Addr)
+}
+
+// ParseAddr parses symbol names or hex addresses like 0150, $0150, 0x0150 or
+// 05:4123. Banked addresses without a bank use the bank that is currently
+// mapped.
+func (d *Debugger) ParseAddr(s string) (BankAddr, error) {
+	if addr, ok := d.symbols.Addr(s); ok {
+		return addr, nil
+	}
+
+	bank, addr, ok := strings.Cut(s, ":")
+	if !ok {
+		addr = bank
+	}
+
+	a, err := parseHex(addr, 16)
+	if err != nil {
+		return BankAddr{}, fmt.Errorf("bad address %q", s)
+	}
+	if !ok {
+		return d.BankAddr(uint16(a)), nil
+	}
+
+	b, err := parseHex(bank, 8)
+	if err != nil {
+		return BankAddr{}, fmt.Errorf("bad bank %q", s)
+	}
+	if !isBanked(uint16(a)) {
+		b = 0
+	}
+	return BankAddr{Bank: uint8(b), Addr: uint16(a)}, nil
+}
+
+func parseHex(s string, bits int) (uint64, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	return strconv.ParseUint(s, 16, bits)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+type Breakpoint struct {
+	Addr BankAddr
+	Cond *Expr
+
+	// Hits counts how many times the breakpoint was reached with its condition
+	// satisfied. The first Ignore hits do not break.
+	Hits   int
+	Ignore int
+}
+
+func (bp *Breakpoint) String() string {
+	s := bp.Addr.String()
+	if bp.Cond != nil {
+		s += " if " + bp.Cond.String()
+	}
+	if bp.Ignore > 0 {
+		s += fmt.Sprintf(" ignore %d", bp.Ignore)
+	}
+	return s + fmt.Sprintf(" (%d hits)", bp.Hits)
+}
+
+func (d *Debugger) ToggleBreakpoint(addr BankAddr) {
+	if _, ok := d.breakpoints[addr]; ok {
+		delete(d.breakpoints, addr)
+	} else {
+		d.breakpoints[addr] = &Breakpoint{Addr: addr}
+	}
+}
+
+// SetBreakpoint creates or replaces the breakpoint at addr. An empty cond
+// always breaks.
+func (d *Debugger) SetBreakpoint(addr BankAddr, cond string, ignore int) error {
+	bp := &Breakpoint{Addr: addr, Ignore: ignore}
+	if cond != "" {
+		expr, err := d.ParseExpr(cond)
+		if err != nil {
+			return err
+		}
+		bp.Cond = expr
+	}
+	d.breakpoints[addr] = bp
+	return nil
+}
+
+func (d *Debugger) ClearBreakpoint(addr BankAddr) {
+	delete(d.breakpoints, addr)
+}
+
+func (d *Debugger) IsBreakpoint(addr BankAddr) bool {
+	_, ok := d.breakpoints[addr]
+	return ok
+}
+
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	var bps []*Breakpoint
+	for _, bp := range d.breakpoints {
+		bps = append(bps, bp)
+	}
+	slices.SortFunc(bps, func(a, b *Breakpoint) int { return a.Addr.Compare(b.Addr) })
+	return bps
+}
+
+// hitBreakpoint is checked by the run loops every time PC changes.
+func (d *Debugger) hitBreakpoint(addr BankAddr) bool {
+	if !d.breakpointCond(addr) {
+		return false
+	}
+	bp := d.breakpoints[addr]
+	bp.Hits++
+	return bp.Hits > bp.Ignore
+}
+
+// breakpointCond reports whether there is a breakpoint at addr whose
+// condition holds, without counting it as a hit.
+func (d *Debugger) breakpointCond(addr BankAddr) bool {
+	bp, ok := d.breakpoints[addr]
+	return ok && (bp.Cond == nil || bp.Cond.Eval(d) != 0)
+}
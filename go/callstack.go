@@ -0,0 +1,49 @@
+package main
+
+import "slices"
+
+// Frame is an entry on the shadow call stack.
+type Frame struct {
+	From      BankAddr // call site or interrupted instruction
+	To        BankAddr // function or interrupt vector
+	Return    uint16
+	SP        uint16 // where the return address is stored
+	Interrupt bool
+}
+
+// CallStack returns the shadow call stack, innermost frame last.
+func (d *Debugger) CallStack() []Frame {
+	return d.callStack
+}
+
+// trackCalls updates the call stack after executing ins at pc with the stack
+// pointer at sp. Frames are dropped as soon as their return address is popped
+// off the stack, which covers ret and reti as well as code that discards
+// return addresses or moves SP itself. The stack is never modified in place so
+// rewinding can keep old versions of it.
+func (d *Debugger) trackCalls(pc BankAddr, ins Instruction, sp uint16) {
+	newSP := uint16(d.Z.CPU.sp)
+	for len(d.callStack) > 0 && d.callStack[len(d.callStack)-1].SP < newSP {
+		d.callStack = d.callStack[:len(d.callStack)-1]
+	}
+
+	to := d.BankAddr(d.PC())
+	switch {
+	case d.Z.CPU.irq_vector != 0:
+		d.Z.CPU.irq_vector = 0
+		d.callStack = append(slices.Clip(d.callStack), Frame{From: pc, To: to, Return: pc.Addr, SP: newSP, Interrupt: true})
+	case ins.IsCall() && newSP == sp-2 && to.Addr == ins.Target:
+		d.callStack = append(slices.Clip(d.callStack), Frame{From: pc, To: to, Return: pc.Addr + uint16(ins.Length), SP: newSP})
+	}
+}
+
+// Describe names addr by its symbol or vector if it has one.
+func (d *Debugger) Describe(addr BankAddr) string {
+	if name, ok := d.symbols.Name(addr); ok {
+		return name
+	}
+	if name, ok := vectorName(addr.Addr); ok && addr.Addr < 0x4000 {
+		return name
+	}
+	return addr.String()
+}
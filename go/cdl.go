@@ -0,0 +1,306 @@
+package main
+
+// #include <stdlib.h>
+// #include "../build/libcgoboy.h"
+import "C"
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// CDLFlags describe how a byte has been accessed since the log was cleared.
+type CDLFlags uint8
+
+const (
+	CDLExec    CDLFlags = C.CDL_EXEC    // first byte of an executed instruction
+	CDLOperand CDLFlags = C.CDL_OPERAND // other bytes of an executed instruction
+	CDLRead    CDLFlags = C.CDL_READ
+	CDLWrite   CDLFlags = C.CDL_WRITE
+)
+
+// enableCDL allocates a code/data log for every rom bank and for ram. The
+// core logs every access from then on. Logs of a previous rom are cleared and
+// reused.
+func (d *Debugger) enableCDL() {
+	keep := min(len(d.cdlBanks), len(d.romBanks))
+	for _, bank := range d.cdlBanks[keep:] {
+		C.free(unsafe.Pointer(bank))
+	}
+	d.cdlBanks = d.cdlBanks[:keep]
+	for len(d.cdlBanks) < len(d.romBanks) {
+		bank, _ := malloc[C.uchar](0x4000)
+		d.cdlBanks = append(d.cdlBanks, bank)
+	}
+	for _, bank := range d.cdlBanks {
+		clear(asSlice(bank, 0x4000))
+	}
+	if d.cdlRAM == nil {
+		d.cdlRAM, _ = malloc[C.uchar](0x8000)
+	}
+	clear(asSlice(d.cdlRAM, 0x8000))
+
+	d.Z.CPU.cdl_rom, d.Z.CPU.cdl_ram = d.cdlBanks[0], d.cdlRAM
+	d.Z.CPU.cdl_xrom = d.cdlBanks[min(int(d.romBank), len(d.cdlBanks)-1)]
+}
+
+// cdl returns the log entry for addr, or nil if there is none.
+func (d *Debugger) cdl(addr BankAddr) *C.uchar {
+	a := int(addr.Addr)
+	switch {
+	case d.cdlRAM == nil:
+		return nil
+	case a < 0x4000:
+		return &asSlice(d.cdlBanks[0], 0x4000)[a]
+	case a < 0x8000:
+		if int(addr.Bank) >= len(d.cdlBanks) {
+			return nil
+		}
+		return &asSlice(d.cdlBanks[addr.Bank], 0x4000)[a-0x4000]
+	case a >= 0xe000 && a < 0xfe00:
+		return &asSlice(d.cdlRAM, 0x8000)[a-0xa000]
+	}
+	return &asSlice(d.cdlRAM, 0x8000)[a-0x8000]
+}
+
+// CDL returns how the byte at addr has been accessed.
+func (d *Debugger) CDL(addr BankAddr) CDLFlags {
+	if f := d.cdl(addr); f != nil {
+		return CDLFlags(*f)
+	}
+	return 0
+}
+
+func (d *Debugger) ClearCDL() {
+	for _, bank := range d.cdlBanks {
+		clear(asSlice(bank, 0x4000))
+	}
+	if d.cdlRAM != nil {
+		clear(asSlice(d.cdlRAM, 0x8000))
+	}
+	d.InvalidateDasmCache()
+}
+
+// CDLStats counts the rom bytes logged as code and as data only.
+func (d *Debugger) CDLStats() (code, data, total int) {
+	for _, bank := range d.cdlBanks {
+		for _, f := range asSlice(bank, 0x4000) {
+			switch {
+			case CDLFlags(f)&(CDLExec|CDLOperand) != 0:
+				code++
+			case CDLFlags(f)&CDLRead != 0:
+				data++
+			}
+		}
+	}
+	return code, data, len(d.cdlBanks) * 0x4000
+}
+
+// isData reports whether addr is in rom and has only been read as data, or
+// would be decoded as an instruction that overlaps one that was executed.
+func (d *Debugger) isData(addr BankAddr, ins Instruction) bool {
+	switch f := d.CDL(addr); {
+	case f&CDLExec != 0:
+		return false
+	case f&CDLOperand != 0, addr.Addr < 0x8000 && f&CDLRead != 0:
+		return true
+	}
+	for i := 1; i < ins.Length; i++ {
+		if d.CDL(d.Offset(addr, i))&CDLExec != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// InstructionStart moves addr back to the start of the executed instruction
+// it is an operand of, if any.
+func (d *Debugger) InstructionStart(addr BankAddr) BankAddr {
+	if d.CDL(addr)&(CDLExec|CDLOperand) != CDLOperand {
+		return addr
+	}
+	for i := 1; i <= 2; i++ {
+		if prev := d.Offset(addr, -i); d.CDL(prev)&CDLExec != 0 {
+			return prev
+		}
+	}
+	return addr
+}
+
+// The BizHawk CDL format used by the Gambatte core. Writes are logged as
+// data as that is all the format has. VRAM is not part of the format.
+const (
+	cdlMagic   = "BIZHAWK-CDL-2"
+	cdlSubType = "GB"
+
+	bizhawkExecFirst   = 0x01
+	bizhawkExecOperand = 0x02
+	bizhawkData        = 0x04
+)
+
+// cdlBlocks returns the blocks of the BizHawk format and where each is
+// logged.
+func (d *Debugger) cdlBlocks() map[string][]C.uchar {
+	ram := asSlice(d.cdlRAM, 0x8000)
+	rom := make([]C.uchar, 0, len(d.cdlBanks)*0x4000)
+	for _, bank := range d.cdlBanks {
+		rom = append(rom, asSlice(bank, 0x4000)...)
+	}
+	return map[string][]C.uchar{
+		"ROM":  rom,
+		"WRAM": ram[0x4000:0x6000],
+		"HRAM": ram[0x7f80:],
+	}
+}
+
+var cdlBlockOrder = []string{"ROM", "HRAM", "WRAM"}
+
+// SaveCDL writes the log in the BizHawk format, which other tools such as
+// disassemblers understand.
+func (d *Debugger) SaveCDL(file string) error {
+	if d.cdlRAM == nil {
+		return fmt.Errorf("no rom loaded")
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	writeNetString(w, cdlMagic)
+	writeNetString(w, cdlSubType+strings.Repeat(" ", 15-len(cdlSubType)))
+	binary.Write(w, binary.LittleEndian, int32(len(cdlBlockOrder)))
+	blocks := d.cdlBlocks()
+	for _, name := range cdlBlockOrder {
+		writeNetString(w, name)
+		binary.Write(w, binary.LittleEndian, int32(len(blocks[name])))
+		for _, flags := range blocks[name] {
+			w.WriteByte(toBizhawk(CDLFlags(flags)))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// LoadCDL merges a log saved by SaveCDL or BizHawk into the current one.
+func (d *Debugger) LoadCDL(file string) error {
+	if d.cdlRAM == nil {
+		return fmt.Errorf("no rom loaded")
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if magic, err := readNetString(r); err != nil || magic != cdlMagic {
+		return fmt.Errorf("%s: not a BizHawk CDL file", file)
+	}
+	if sub, err := readNetString(r); err != nil || strings.TrimSpace(sub) != cdlSubType {
+		return fmt.Errorf("%s: not a Game Boy CDL file", file)
+	}
+	var count int32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return err
+	}
+
+	blocks := d.cdlBlocks()
+	for i := 0; i < int(count); i++ {
+		name, err := readNetString(r)
+		if err != nil {
+			return err
+		}
+		var n int32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return err
+		}
+		if n < 0 {
+			return fmt.Errorf("%s: %s has bad length %d", file, name, n)
+		}
+
+		block, ok := blocks[name]
+		if !ok {
+			// e.g. SRAM or CartRAM, which aren't logged
+			if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+				return err
+			}
+			continue
+		}
+		if int(n) != len(block) {
+			return fmt.Errorf("%s: %s is %d bytes, expected %d", file, name, n, len(block))
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		if name != "ROM" {
+			for i, b := range data {
+				block[i] |= C.uchar(fromBizhawk(b))
+			}
+			continue
+		}
+		// the rom block was copied out of the banks
+		for i, b := range data {
+			asSlice(d.cdlBanks[i/0x4000], 0x4000)[i%0x4000] |= C.uchar(fromBizhawk(b))
+		}
+	}
+	d.InvalidateDasmCache()
+	return nil
+}
+
+func toBizhawk(f CDLFlags) byte {
+	var b byte
+	if f&CDLExec != 0 {
+		b |= bizhawkExecFirst
+	}
+	if f&CDLOperand != 0 {
+		b |= bizhawkExecOperand
+	}
+	if f&(CDLRead|CDLWrite) != 0 {
+		b |= bizhawkData
+	}
+	return b
+}
+
+func fromBizhawk(b byte) CDLFlags {
+	var f CDLFlags
+	if b&bizhawkExecFirst != 0 {
+		f |= CDLExec
+	}
+	if b&bizhawkExecOperand != 0 {
+		f |= CDLOperand
+	}
+	if b&bizhawkData != 0 {
+		f |= CDLRead
+	}
+	return f
+}
+
+// writeNetString writes s like .NET's BinaryWriter, prefixed with its length
+// as a 7-bit varint.
+func writeNetString(w *bufio.Writer, s string) {
+	var buf [binary.MaxVarintLen32]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(s)))])
+	w.WriteString(s)
+}
+
+func readNetString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > 1<<16 {
+		return "", fmt.Errorf("bad string length %d", n)
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	return string(buf), err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCDL(t *testing.T) {
+	d := loadTestROM(t, map[uint16][]byte{
+		0x0100: {
+			0xaf,       // xor a
+			0x20, 0x05, // jr nz, not taken
+			0xc2, 0x00, 0x02, // jp nz, not taken
+			0xfa, 0x00, 0x30, // ld a, [$3000]
+			0x18, 0xfe, // jr @
+		},
+	})
+	for i := 0; i < 4; i++ {
+		d.StepInto()
+	}
+	want := map[uint16]CDLFlags{
+		0x0100: CDLExec,
+		0x0101: CDLExec,
+		0x0102: CDLOperand,
+		0x0103: CDLExec,
+		0x0104: CDLOperand,
+		0x0105: CDLOperand,
+		0x0106: CDLExec,
+		0x0107: CDLOperand,
+		0x0108: CDLOperand,
+		0x0109: 0,
+		0x3000: CDLRead,
+	}
+	check := func(when string) {
+		t.Helper()
+		for addr, flags := range want {
+			if got := d.CDL(BankAddr{Addr: addr}); got != flags {
+				t.Errorf("%s: %04X logged as %b, want %b", when, addr, got, flags)
+			}
+		}
+	}
+	check("run")
+
+	file := filepath.Join(t.TempDir(), "test.cdl")
+	if err := d.SaveCDL(file); err != nil {
+		t.Fatal(err)
+	}
+	d.ClearCDL()
+	if got := d.CDL(BankAddr{Addr: 0x0100}); got != 0 {
+		t.Errorf("cleared: 0100 logged as %b", got)
+	}
+	if err := d.LoadCDL(file); err != nil {
+		t.Fatal(err)
+	}
+	check("loaded")
+
+	// reloading a rom of the same size reuses the logs
+	bank := d.cdlBanks[0]
+	if err := d.Load(d.romPath); err != nil {
+		t.Fatal(err)
+	}
+	if d.cdlBanks[0] != bank || len(d.cdlBanks) != 2 {
+		t.Errorf("reload allocated new logs")
+	}
+	if got := d.CDL(BankAddr{Addr: 0x0100}); got != 0 {
+		t.Errorf("reloaded: 0100 logged as %b", got)
+	}
+}
+
+func TestLoadCDLCorrupt(t *testing.T) {
+	d := loadTestROM(t, nil)
+	tests := []struct {
+		name  string
+		block string
+		n     int32
+	}{
+		{"negative", "ROM", -1},
+		{"huge", "ROM", 0x7fffffff},
+		{"short", "ROM", 0x4000},
+		{"truncated", "WRAM", 0x2000},
+		{"unknown truncated", "SRAM", 0x7fffffff},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		writeNetString(w, cdlMagic)
+		writeNetString(w, "GB             ")
+		binary.Write(w, binary.LittleEndian, int32(1))
+		writeNetString(w, tt.block)
+		binary.Write(w, binary.LittleEndian, tt.n)
+		w.Write(make([]byte, 16))
+		w.Flush()
+
+		file := filepath.Join(t.TempDir(), "bad.cdl")
+		if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.LoadCDL(file); err == nil {
+			t.Errorf("%s: loaded a %s block of %d bytes", tt.name, tt.block, tt.n)
+		}
+	}
+}
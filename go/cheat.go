@@ -0,0 +1,222 @@
+package main
+
+// #include "../build/libcgoboy.h"
+import "C"
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type CheatKind int
+
+const (
+	GameGenie CheatKind = iota
+	GameShark
+)
+
+// Cheat is a Game Genie code, which replaces a byte read from rom, or a
+// GameShark code, which writes a byte to ram every frame. Compare and Bank
+// are -1 when the code does not have them.
+type Cheat struct {
+	Code    string
+	Name    string
+	Kind    CheatKind
+	Addr    uint16
+	Value   uint8
+	Compare int
+	Bank    int
+	Enabled bool
+}
+
+func (c *Cheat) String() string {
+	s := fmt.Sprintf("%-11s %s %04X=%02X", c.Code, tern(c.Enabled, "on ", "off"), c.Addr, c.Value)
+	if c.Compare >= 0 {
+		s += fmt.Sprintf(" if %02X", c.Compare)
+	}
+	if c.Bank >= 0 {
+		s += fmt.Sprintf(" in bank %d", c.Bank)
+	}
+	if c.Name != "" {
+		s += " " + c.Name
+	}
+	return s
+}
+
+// ParseCheat parses a Game Genie code, ABC-DEF or ABC-DEF-GHI, or a
+// GameShark code, TTVVLLHH.
+func ParseCheat(code string) (*Cheat, error) {
+	code = strings.ToUpper(code)
+	digits := strings.ReplaceAll(code, "-", "")
+	n, err := strconv.ParseUint(digits, 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad cheat %q", code)
+	}
+
+	c := &Cheat{Code: code, Compare: -1, Bank: -1, Enabled: true}
+	switch {
+	case len(digits) == 8 && !strings.Contains(code, "-"):
+		// type, value, address low, address high
+		c.Kind = GameShark
+		c.Value = uint8(n >> 16)
+		c.Addr = uint16(n>>8&0xff) | uint16(n&0xff)<<8
+		switch t := int(n >> 24); {
+		case t == 0x00 || t == 0x01:
+		case t&0xf0 == 0x80 && c.Addr >= 0xa000 && c.Addr < 0xc000:
+			c.Bank = t & 0x0f
+		default:
+			return nil, fmt.Errorf("unsupported GameShark code type %02X", t)
+		}
+		if c.Addr < 0x8000 {
+			return nil, fmt.Errorf("GameShark code writes to rom at %04X", c.Addr)
+		}
+	case len(digits) == 6 && len(code) == 7 || len(digits) == 9 && len(code) == 11:
+		// the address is FCDE xor f000, the compare GI rotated and xored
+		digit := func(i int) uint16 { v, _ := strconv.ParseUint(digits[i:i+1], 16, 8); return uint16(v) }
+		c.Kind = GameGenie
+		c.Value = uint8(digit(0)<<4 | digit(1))
+		c.Addr = (digit(5)<<12 | digit(2)<<8 | digit(3)<<4 | digit(4)) ^ 0xf000
+		if len(digits) == 9 {
+			gi := uint8(digit(6)<<4 | digit(8))
+			c.Compare = int((gi>>2 | gi<<6) ^ 0xba)
+		}
+		if c.Addr >= 0x8000 {
+			return nil, fmt.Errorf("Game Genie code patches %04X, outside rom", c.Addr)
+		}
+	default:
+		return nil, fmt.Errorf("bad cheat %q", code)
+	}
+	return c, nil
+}
+
+func (d *Debugger) Cheats() []*Cheat {
+	return d.cheats
+}
+
+// AddCheat adds an enabled code.
+func (d *Debugger) AddCheat(code, name string) (*Cheat, error) {
+	c, err := ParseCheat(code)
+	if err != nil {
+		return nil, err
+	}
+	c.Name = name
+	d.cheats = append(d.cheats, c)
+	if err := d.syncCheats(); err != nil {
+		d.cheats = d.cheats[:len(d.cheats)-1]
+		return nil, err
+	}
+	return c, d.saveCheats()
+}
+
+func (d *Debugger) RemoveCheat(i int) error {
+	if i < 0 || i >= len(d.cheats) {
+		return fmt.Errorf("no cheat %d", i)
+	}
+	d.cheats = append(d.cheats[:i], d.cheats[i+1:]...)
+	d.syncCheats()
+	return d.saveCheats()
+}
+
+// EnableCheat turns cheat i on or off. Changes take effect on the next read
+// or frame.
+func (d *Debugger) EnableCheat(i int, on bool) error {
+	if i < 0 || i >= len(d.cheats) {
+		return fmt.Errorf("no cheat %d", i)
+	}
+	was := d.cheats[i].Enabled
+	d.cheats[i].Enabled = on
+	if err := d.syncCheats(); err != nil {
+		d.cheats[i].Enabled = was
+		return err
+	}
+	return d.saveCheats()
+}
+
+// syncCheats copies the enabled cheats to the core.
+func (d *Debugger) syncCheats() error {
+	z := &d.Z.CPU
+	var genie, shark int
+	for _, c := range d.cheats {
+		if !c.Enabled {
+			continue
+		}
+		switch {
+		case c.Kind == GameGenie && genie < C.CHEATS_MAX:
+			z.genie[genie] = C.genie_code{
+				addr:        C.ushort(c.Addr),
+				value:       C.uchar(c.Value),
+				compare:     C.uchar(max(c.Compare, 0)),
+				has_compare: C.uchar(tern(c.Compare >= 0, 1, 0)),
+			}
+			genie++
+		case c.Kind == GameShark && shark < C.CHEATS_MAX:
+			z.shark[shark] = C.shark_code{
+				addr:     C.ushort(c.Addr),
+				value:    C.uchar(c.Value),
+				bank:     C.uchar(max(c.Bank, 0)),
+				has_bank: C.uchar(tern(c.Bank >= 0, 1, 0)),
+			}
+			shark++
+		default:
+			return fmt.Errorf("at most %d codes of each kind can be on", C.CHEATS_MAX)
+		}
+	}
+	z.genie_len, z.shark_len = C.uchar(genie), C.uchar(shark)
+	return nil
+}
+
+// The cheats of a rom are kept next to it, one per line as the code, on or
+// off and an optional name.
+func cheatFile(rom string) string {
+	return strings.TrimSuffix(rom, filepath.Ext(rom)) + ".cht"
+}
+
+func (d *Debugger) loadCheats(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		c, err := ParseCheat(fields[0])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		if len(fields) > 1 {
+			c.Enabled = fields[1] != "off"
+			c.Name = strings.Join(fields[2:], " ")
+		}
+		d.cheats = append(d.cheats, c)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return d.syncCheats()
+}
+
+func (d *Debugger) saveCheats() error {
+	if d.romPath == "" {
+		return nil
+	}
+	file := cheatFile(d.romPath)
+	if len(d.cheats) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	var b strings.Builder
+	for _, c := range d.cheats {
+		fmt.Fprintln(&b, strings.TrimSpace(c.Code+" "+tern(c.Enabled, "on ", "off ")+c.Name))
+	}
+	return os.WriteFile(file, []byte(b.String()), 0644)
+}
@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func TestParseCheat(t *testing.T) {
+	tests := []struct {
+		code string
+		want Cheat
+	}{
+		// Game Genie: value AB, address FCDE xor f000, compare GI rotated right 2 xor ba
+		{"00A-17B-C49", Cheat{Code: "00A-17B-C49", Kind: GameGenie, Addr: 0x4a17, Value: 0x00, Compare: 0xc8, Bank: -1}},
+		{"3eb-3cf", Cheat{Code: "3EB-3CF", Kind: GameGenie, Addr: 0x0b3c, Value: 0x3e, Compare: -1, Bank: -1}},
+		// GameShark: type, value, address low, address high
+		{"01FF40C1", Cheat{Code: "01FF40C1", Kind: GameShark, Addr: 0xc140, Value: 0xff, Compare: -1, Bank: -1}},
+		{"00630AD0", Cheat{Code: "00630AD0", Kind: GameShark, Addr: 0xd00a, Value: 0x63, Compare: -1, Bank: -1}},
+		{"820AA0A0", Cheat{Code: "820AA0A0", Kind: GameShark, Addr: 0xa0a0, Value: 0x0a, Compare: -1, Bank: 2}},
+	}
+	for _, tt := range tests {
+		got, err := ParseCheat(tt.code)
+		if err != nil {
+			t.Errorf("%s: %v", tt.code, err)
+			continue
+		}
+		tt.want.Enabled = true
+		if *got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.code, *got, tt.want)
+		}
+	}
+
+	for _, code := range []string{
+		"3EB-3C7",   // patches 8b3c, outside rom
+		"3EB3CF",    // missing dashes
+		"3EB-3CF-2", // wrong length
+		"01FF0040",  // GameShark writing to rom
+		"81FF00C0",  // banked outside cart ram
+		"0AFF40C1",  // unknown type
+		"01FF40C",   // too short
+		"01FG40C1",  // not hex
+	} {
+		if c, err := ParseCheat(code); err == nil {
+			t.Errorf("%s: parsed as %s", code, c)
+		}
+	}
+}
+
+func TestCheats(t *testing.T) {
+	d := loadTestROM(t, map[uint16][]byte{
+		0x0100: {0xfa, 0x00, 0x30}, // ld a, [$3000]
+		0x0103: {0x18, 0xfe},       // jr @
+		0x3000: {0x12},
+	})
+	d.EnableRewind(DefaultRewindInstructions, DefaultRewindFrames)
+	d.RunFor(20000) // into the second frame
+
+	// 3000 reads as 99 instead of 12
+	if _, err := d.AddCheat("990-00C-AE2", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Cheats()[0]; got.Addr != 0x3000 || got.Value != 0x99 || got.Compare != 0x12 {
+		t.Fatalf("got cheat %s", got)
+	}
+
+	// rewinding to before the cheat was added keeps it
+	if d.RewindFrames(1) == 0 {
+		t.Fatal("nothing to rewind")
+	}
+	d.SetRegister("pc", 0x0100)
+	d.StepInto()
+	if a := d.Z.CPU.a; a != 0x99 {
+		t.Errorf("read %02x with the cheat on, want 99", a)
+	}
+	if err := d.EnableCheat(0, false); err != nil {
+		t.Fatal(err)
+	}
+	d.SetRegister("pc", 0x0100)
+	d.StepInto()
+	if a := d.Z.CPU.a; a != 0x12 {
+		t.Errorf("read %02x with the cheat off, want 12", a)
+	}
+}
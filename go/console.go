@@ -0,0 +1,820 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jroimartin/gocui"
+)
+
+type command struct {
+	names []string
+	usage string
+	run   func(args []string) error
+}
+
+func (cli *CLI) initCommands() {
+	cli.commands = []command{
+		{[]string{"help", "?"}, "[command]", cli.cmdHelp},
+		{[]string{"step", "s"}, "[count]", cli.cmdStep},
+		{[]string{"next", "n"}, "", func(_ []string) error { cli.Debugger.StepOver(); cli.JumpToDasm(); return nil }},
+		{[]string{"run", "r", "continue", "c"}, "", func(_ []string) error { cli.Debugger.Run(); cli.JumpToDasm(); return nil }},
+		{[]string{"finish", "out", "o"}, "", func(_ []string) error { cli.Debugger.StepOut(); cli.JumpToDasm(); return nil }},
+		{[]string{"until", "u"}, "[addr]", cli.cmdUntil},
+		{[]string{"back", "bs"}, "[count]", cli.cmdBack},
+		{[]string{"reverse", "rc"}, "", func(_ []string) error { cli.Debugger.ReverseContinue(); cli.JumpToDasm(); return nil }},
+		{[]string{"rewind"}, "[frames]", cli.cmdRewind},
+		{[]string{"goto", "g"}, "addr", cli.cmdGoto},
+		{[]string{"mem", "m"}, "addr [count]", cli.cmdMem},
+		{[]string{"print", "p"}, "expr", cli.cmdPrint},
+		{[]string{"write"}, "addr byte...", cli.cmdWrite(WriteCPU)},
+		{[]string{"poke"}, "addr byte...", cli.cmdWrite(WriteRaw)},
+		{[]string{"fill"}, "addr-addr byte [raw]", cli.cmdFill},
+		{[]string{"copy"}, "addr-addr addr [raw]", cli.cmdCopy},
+		{[]string{"set"}, "reg|flag=value", cli.cmdSet},
+		{[]string{"asm", "a"}, "[addr] instruction", cli.cmdAsm},
+		{[]string{"patches"}, "[save file.bps]", cli.cmdPatches},
+		{[]string{"revert"}, "index", cli.cmdRevert},
+		{[]string{"bp", "b"}, "[addr [if expr] [ignore n]]", cli.cmdBreakpoint},
+		{[]string{"del", "d"}, "addr", cli.cmdDelete},
+		{[]string{"watch", "w"}, "[addr[-addr] r|w|c [value]]", cli.cmdWatch},
+		{[]string{"unwatch"}, "index", cli.cmdUnwatch},
+		{[]string{"trace"}, "off|file [addr-addr [bank]]", cli.cmdTrace},
+		{[]string{"profile"}, "[start|stop|report [n]|save file]", cli.cmdProfile},
+		{[]string{"cdl"}, "[save|load file|clear]", cli.cmdCDL},
+		{[]string{"coverage"}, "[file.info]", cli.cmdCoverage},
+		{[]string{"search"}, "[new [16] [bcd]|equal|changed|increased|decreased|value n|off]", cli.cmdSearch},
+		{[]string{"cheat"}, "[add code [name]|del|on|off index]", cli.cmdCheat},
+		{[]string{"source"}, "file", cli.cmdSource},
+		{[]string{"quit", "q"}, "", func(_ []string) error { cli.g.Update(func(_ *gocui.Gui) error { return gocui.ErrQuit }); return nil }},
+	}
+}
+
+func (cli *CLI) findCommand(name string) *command {
+	for i, c := range cli.commands {
+		if slices.Contains(c.names, name) {
+			return &cli.commands[i]
+		}
+	}
+	return nil
+}
+
+// Exec runs one console command line.
+func (cli *CLI) Exec(line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return nil
+	}
+
+	c := cli.findCommand(strings.ToLower(args[0]))
+	if c == nil {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return c.run(args[1:])
+}
+
+// printf writes a line to the output view. It must be called from the gocui
+// main loop.
+func (cli *CLI) printf(format string, a ...any) {
+	if v, err := cli.g.View(ViewOutput); err == nil {
+		fmt.Fprintf(v, format+"\n", a...)
+	}
+}
+
+func (cli *CLI) cmdHelp(args []string) error {
+	if len(args) > 0 {
+		c := cli.findCommand(args[0])
+		if c == nil {
+			return fmt.Errorf("unknown command %q", args[0])
+		}
+		cli.printf("%s %s", strings.Join(c.names, "|"), c.usage)
+		return nil
+	}
+
+	var names []string
+	for _, c := range cli.commands {
+		names = append(names, c.names[0])
+	}
+	cli.printf("%s", strings.Join(names, " "))
+	return nil
+}
+
+func (cli *CLI) cmdStep(args []string) error {
+	n := 1
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < n; i++ {
+		cli.Debugger.StepInto()
+	}
+	cli.JumpToDasm()
+	return nil
+}
+
+func (cli *CLI) cmdBack(args []string) error {
+	n := 1
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < n; i++ {
+		if !cli.Debugger.StepBack() {
+			cli.printf("no more history after %d", i)
+			break
+		}
+	}
+	cli.JumpToDasm()
+	return nil
+}
+
+func (cli *CLI) cmdRewind(args []string) error {
+	n := 1
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil {
+			return err
+		}
+	}
+	if rewound := cli.Debugger.RewindFrames(n); rewound < n {
+		cli.printf("rewound %d frames", rewound)
+	}
+	cli.JumpToDasm()
+	return nil
+}
+
+// cmdUntil runs to addr, or to the disassembly cursor without one.
+func (cli *CLI) cmdUntil(args []string) error {
+	addr, ok := cli.cursorAddr()
+	if len(args) > 0 {
+		var err error
+		if addr, err = cli.Debugger.ParseAddr(args[0]); err != nil {
+			return err
+		}
+	} else if !ok {
+		return fmt.Errorf("usage: until [addr]")
+	}
+	cli.Debugger.RunTo(addr)
+	cli.JumpToDasm()
+	return nil
+}
+
+func (cli *CLI) cmdGoto(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: goto addr")
+	}
+	addr, err := cli.Debugger.ParseAddr(args[0])
+	if err != nil {
+		return err
+	}
+	cli.dasmStartAddr = addr
+	cli.dasmCursor = 0
+	return nil
+}
+
+func (cli *CLI) cmdMem(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: mem addr [count]")
+	}
+	addr, err := cli.Debugger.ParseAddr(args[0])
+	if err != nil {
+		return err
+	}
+	cli.memStartAddr = addr.Addr
+	cli.memCursor = addr.Addr
+
+	if len(args) == 2 {
+		n, err := parseHex(args[1], 16)
+		if err != nil {
+			return err
+		}
+		var sb strings.Builder
+		for i := 0; i < int(n); i++ {
+			fmt.Fprintf(&sb, "%02X ", cli.Debugger.ReadBank(cli.Debugger.Offset(addr, i)))
+		}
+		cli.printf("%s: %s", addr, sb.String())
+	}
+	return nil
+}
+
+func (cli *CLI) cmdPrint(args []string) error {
+	expr, err := cli.Debugger.ParseExpr(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	n := expr.Eval(cli.Debugger)
+	cli.printf("%s = %d ($%X)", expr, n, n)
+	return nil
+}
+
+func (cli *CLI) cmdWrite(mode WriteMode) func(args []string) error {
+	return func(args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("usage: write|poke addr byte...")
+		}
+		addr, err := cli.Debugger.ParseAddr(args[0])
+		if err != nil {
+			return err
+		}
+		var bytes []byte
+		for _, arg := range args[1:] {
+			b, err := parseHex(arg, 8)
+			if err != nil {
+				return err
+			}
+			bytes = append(bytes, byte(b))
+		}
+		for i, b := range bytes {
+			cli.Debugger.Write(cli.Debugger.Offset(addr, i), b, mode)
+		}
+		return nil
+	}
+}
+
+// parseRange parses addr-addr into a start address and an inclusive length.
+func (cli *CLI) parseRange(s string) (BankAddr, int, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return BankAddr{}, 0, fmt.Errorf("bad range %q", s)
+	}
+	start, err := cli.Debugger.ParseAddr(from)
+	if err != nil {
+		return BankAddr{}, 0, err
+	}
+	end, err := cli.Debugger.ParseAddr(to)
+	if err != nil {
+		return BankAddr{}, 0, err
+	}
+	if end.Addr < start.Addr {
+		return BankAddr{}, 0, fmt.Errorf("bad range %q", s)
+	}
+	return start, int(end.Addr-start.Addr) + 1, nil
+}
+
+func parseWriteMode(args []string) (WriteMode, error) {
+	if len(args) == 0 {
+		return WriteCPU, nil
+	} else if len(args) == 1 && args[0] == "raw" {
+		return WriteRaw, nil
+	}
+	return WriteCPU, fmt.Errorf("unexpected %q", strings.Join(args, " "))
+}
+
+func (cli *CLI) cmdFill(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: fill addr-addr byte [raw]")
+	}
+	start, n, err := cli.parseRange(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := parseHex(args[1], 8)
+	if err != nil {
+		return err
+	}
+	mode, err := parseWriteMode(args[2:])
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		cli.Debugger.Write(cli.Debugger.Offset(start, i), byte(b), mode)
+	}
+	return nil
+}
+
+func (cli *CLI) cmdCopy(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: copy addr-addr addr [raw]")
+	}
+	src, n, err := cli.parseRange(args[0])
+	if err != nil {
+		return err
+	}
+	dst, err := cli.Debugger.ParseAddr(args[1])
+	if err != nil {
+		return err
+	}
+	mode, err := parseWriteMode(args[2:])
+	if err != nil {
+		return err
+	}
+
+	// read everything first in case the ranges overlap
+	bytes := make([]byte, n)
+	for i := range bytes {
+		bytes[i] = cli.Debugger.ReadBank(cli.Debugger.Offset(src, i))
+	}
+	for i, b := range bytes {
+		cli.Debugger.Write(cli.Debugger.Offset(dst, i), b, mode)
+	}
+	return nil
+}
+
+func (cli *CLI) cmdSet(args []string) error {
+	reg, value, ok := strings.Cut(strings.Join(args, ""), "=")
+	if !ok {
+		return fmt.Errorf("usage: set reg|flag=value")
+	}
+	expr, err := cli.Debugger.ParseExpr(value)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(cpuFlags, strings.ToLower(reg)) {
+		return cli.Debugger.SetFlag(reg, expr.Eval(cli.Debugger) != 0)
+	}
+	return cli.Debugger.SetRegister(reg, expr.Eval(cli.Debugger))
+}
+
+// cmdAsm patches an instruction over addr, or over the disassembly cursor
+// without one. Mnemonics like add and dec are also hex, so they win.
+func (cli *CLI) cmdAsm(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: asm [addr] instruction")
+	}
+	addr, ok := cli.cursorAddr()
+	if !sourceMnemonics[strings.ToLower(args[0])] {
+		var err error
+		if addr, err = cli.Debugger.ParseAddr(args[0]); err != nil {
+			return err
+		}
+		args = args[1:]
+	} else if !ok {
+		return fmt.Errorf("usage: asm [addr] instruction")
+	}
+	p, warning, err := cli.Debugger.Patch(addr, strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		cli.printf("warning: %s", warning)
+	}
+	cli.printf("%d: %s", len(cli.Debugger.Patches())-1, p)
+	return nil
+}
+
+func (cli *CLI) cmdPatches(args []string) error {
+	if len(args) == 2 && args[0] == "save" {
+		return cli.Debugger.SaveBPS(args[1])
+	} else if len(args) != 0 {
+		return fmt.Errorf("usage: patches [save file.bps]")
+	}
+	for i, p := range cli.Debugger.Patches() {
+		cli.printf("%d: %s", i, p)
+	}
+	return nil
+}
+
+func (cli *CLI) cmdRevert(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: revert index")
+	}
+	i, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+	return cli.Debugger.RevertPatch(i)
+}
+
+func (cli *CLI) cmdBreakpoint(args []string) error {
+	if len(args) == 0 {
+		for _, bp := range cli.Debugger.Breakpoints() {
+			cli.printf("%s", bp)
+		}
+		return nil
+	}
+
+	addr, err := cli.Debugger.ParseAddr(args[0])
+	if err != nil {
+		return err
+	}
+
+	var cond string
+	var ignore int
+	rest := args[1:]
+	if n := len(rest); n >= 2 && rest[n-2] == "ignore" {
+		if ignore, err = strconv.Atoi(rest[n-1]); err != nil {
+			return err
+		}
+		rest = rest[:n-2]
+	}
+	if len(rest) > 0 {
+		if rest[0] != "if" || len(rest) == 1 {
+			return fmt.Errorf("usage: bp addr [if expr] [ignore n]")
+		}
+		cond = strings.Join(rest[1:], " ")
+	}
+	return cli.Debugger.SetBreakpoint(addr, cond, ignore)
+}
+
+func (cli *CLI) cmdDelete(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: del addr")
+	}
+	addr, err := cli.Debugger.ParseAddr(args[0])
+	if err != nil {
+		return err
+	}
+	if !cli.Debugger.IsBreakpoint(addr) {
+		return fmt.Errorf("no breakpoint at %s", addr)
+	}
+	cli.Debugger.ClearBreakpoint(addr)
+	return nil
+}
+
+func (cli *CLI) cmdWatch(args []string) error {
+	if len(args) == 0 {
+		for i, w := range cli.Debugger.Watchpoints() {
+			cli.printf("%d: %s", i, w)
+		}
+		return nil
+	}
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("usage: watch addr[-addr] r|w|c [value]")
+	}
+
+	from, to, ok := strings.Cut(args[0], "-")
+	start, err := cli.Debugger.ParseAddr(from)
+	if err != nil {
+		return err
+	}
+	end := start
+	if ok {
+		if end, err = cli.Debugger.ParseAddr(to); err != nil {
+			return err
+		}
+	}
+
+	kinds := map[string]WatchKind{"r": WatchRead, "w": WatchWrite, "c": WatchChange}
+	kind, ok := kinds[args[1]]
+	if !ok {
+		return fmt.Errorf("unknown watch kind %q", args[1])
+	}
+
+	value := AnyValue
+	if len(args) == 3 {
+		if kind != WatchChange {
+			return fmt.Errorf("value is only valid for c")
+		}
+		n, err := parseHex(args[2], 8)
+		if err != nil {
+			return err
+		}
+		value = int(n)
+	}
+
+	cli.Debugger.AddWatchpoint(start.Addr, end.Addr, kind, value)
+	return nil
+}
+
+func (cli *CLI) cmdUnwatch(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: unwatch index")
+	}
+	i, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+	ws := cli.Debugger.Watchpoints()
+	if i < 0 || i >= len(ws) {
+		return fmt.Errorf("no watchpoint %d", i)
+	}
+	cli.Debugger.RemoveWatchpoint(ws[i])
+	return nil
+}
+
+func (cli *CLI) cmdTrace(args []string) error {
+	if len(args) == 0 {
+		cli.printf("tracing: %v", cli.Debugger.Tracing())
+		return nil
+	}
+	if args[0] == "off" {
+		return cli.Debugger.StopTrace()
+	}
+	if len(args) > 3 {
+		return fmt.Errorf("usage: trace off|file [addr-addr [bank]]")
+	}
+
+	var rng, bank string
+	if len(args) > 1 {
+		rng = args[1]
+	}
+	if len(args) > 2 {
+		bank = args[2]
+	}
+	filter, err := ParseTraceFilter(rng, bank)
+	if err != nil {
+		return err
+	}
+	return cli.Debugger.StartTrace(args[0], filter, false)
+}
+
+func (cli *CLI) cmdProfile(args []string) error {
+	d := cli.Debugger
+	report := func(n int) error {
+		var b strings.Builder
+		d.WriteProfileReport(&b, n)
+		for _, line := range strings.Split(strings.TrimRight(b.String(), "\n"), "\n") {
+			cli.printf("%s", line)
+		}
+		return nil
+	}
+	switch {
+	case len(args) == 0:
+		cli.printf("profiling: %v", d.Profiling())
+		return nil
+	case len(args) == 1 && args[0] == "start":
+		d.StartProfile()
+		return nil
+	case len(args) == 1 && args[0] == "stop":
+		d.StopProfile()
+		return report(10)
+	case len(args) <= 2 && args[0] == "report":
+		n := 10
+		if len(args) == 2 {
+			var err error
+			if n, err = strconv.Atoi(args[1]); err != nil {
+				return err
+			}
+		}
+		return report(n)
+	case len(args) == 2 && args[0] == "save":
+		return saveProfile(d, args[1])
+	}
+	return fmt.Errorf("usage: profile [start|stop|report [n]|save file]")
+}
+
+func (cli *CLI) cmdCDL(args []string) error {
+	d := cli.Debugger
+	switch {
+	case len(args) == 0:
+		code, data, total := d.CDLStats()
+		if total == 0 {
+			return fmt.Errorf("no rom loaded")
+		}
+		cli.printf("rom: %d code, %d data, %d unknown (%.1f%% logged)", code, data, total-code-data, float64(code+data)*100/float64(total))
+		return nil
+	case len(args) == 1 && args[0] == "clear":
+		d.ClearCDL()
+		return nil
+	case len(args) == 2 && args[0] == "save":
+		return d.SaveCDL(args[1])
+	case len(args) == 2 && args[0] == "load":
+		return d.LoadCDL(args[1])
+	}
+	return fmt.Errorf("usage: cdl [save|load file|clear]")
+}
+
+// cmdCoverage prints how much of each label has run, and with a file, saves
+// it as lcov.
+func (cli *CLI) cmdCoverage(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: coverage [file.info]")
+	}
+	c := cli.Debugger.Coverage()
+	if len(args) == 1 {
+		return cli.Debugger.SaveLCOV(args[0], c)
+	}
+	var b strings.Builder
+	c.WriteReport(&b)
+	for _, line := range strings.Split(strings.TrimRight(b.String(), "\n"), "\n") {
+		cli.printf("%s", line)
+	}
+	return nil
+}
+
+// cmdSearch starts a ram search or narrows it down. Starting a search takes a
+// snapshot for the first filter to compare against.
+func (cli *CLI) cmdSearch(args []string) error {
+	usage := fmt.Errorf("usage: search [new [16] [bcd]|equal|changed|increased|decreased|value n|off]")
+	if len(args) == 0 {
+		if cli.search == nil {
+			return fmt.Errorf("no search, start one with search new")
+		}
+		cli.printf("search: %s", cli.search)
+		return nil
+	}
+
+	switch args[0] {
+	case "new":
+		size, bcd := 1, false
+		for _, arg := range args[1:] {
+			switch arg {
+			case "8":
+				size = 1
+			case "16":
+				size = 2
+			case "bcd":
+				bcd = true
+			default:
+				return usage
+			}
+		}
+		cli.search = NewRAMSearch(cli.Debugger, size, bcd)
+		cli.searchCursor = 0
+	case "off":
+		cli.search = nil
+		return nil
+	default:
+		op, ok := searchOps[args[0]]
+		if !ok || (op == SearchValue) != (len(args) == 2) || len(args) > 2 {
+			return usage
+		}
+		if cli.search == nil {
+			return fmt.Errorf("no search, start one with search new")
+		}
+		value := 0
+		if op == SearchValue {
+			expr, err := cli.Debugger.ParseExpr(args[1])
+			if err != nil {
+				return err
+			}
+			value = expr.Eval(cli.Debugger)
+		}
+		cli.search.Filter(op, value)
+	}
+	cli.printf("search: %s", cli.search)
+	return nil
+}
+
+func (cli *CLI) cmdCheat(args []string) error {
+	d := cli.Debugger
+	if len(args) == 0 {
+		for i, c := range d.Cheats() {
+			cli.printf("%d: %s", i, c)
+		}
+		return nil
+	}
+	if args[0] == "add" && len(args) > 1 {
+		_, err := d.AddCheat(args[1], strings.Join(args[2:], " "))
+		return err
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: cheat [add code [name]|del|on|off index]")
+	}
+	i, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "del":
+		return d.RemoveCheat(i)
+	case "on", "off":
+		return d.EnableCheat(i, args[0] == "on")
+	}
+	return fmt.Errorf("usage: cheat [add code [name]|del|on|off index]")
+}
+
+func (cli *CLI) cmdSource(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: source file")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := cli.Exec(line); err != nil {
+			return fmt.Errorf("%s:%d: %w", args[0], n, err)
+		}
+	}
+	return s.Err()
+}
+
+func (cli *CLI) RenderConsole(g *gocui.Gui) error {
+	maxX, maxY := g.Size()
+
+	if _, err := g.View(ViewOutput); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v, _ := g.SetView(ViewOutput, 36, maxY-8, maxX-1, maxY-4)
+		v.Title = ViewOutput
+		v.Wrap = true
+		v.Autoscroll = true
+	}
+
+	if _, err := g.View(ViewConsole); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v, _ := g.SetView(ViewConsole, 36, maxY-3, maxX-1, maxY-1)
+		v.Title = ViewConsole + " (:)"
+		v.Editable = true
+	}
+
+	return nil
+}
+
+func (cli *CLI) focusConsole() {
+	cli.g.Cursor = true
+	cli.g.SetCurrentView(ViewConsole)
+}
+
+func (cli *CLI) blurConsole(v *gocui.View) {
+	v.Clear()
+	v.SetCursor(0, 0)
+	v.SetOrigin(0, 0)
+	cli.g.Cursor = false
+	cli.g.SetCurrentView(tern(cli.memEditing, ViewMemory, ViewDisassembly))
+}
+
+func (cli *CLI) setConsoleLine(v *gocui.View, line string) {
+	v.Clear()
+	v.SetOrigin(0, 0)
+	fmt.Fprint(v, line)
+	v.SetCursor(len(line), 0)
+}
+
+func (cli *CLI) bindConsole() {
+	cli.bindView(ViewConsole, gocui.KeyEnter, func(v *gocui.View) {
+		line := strings.TrimSpace(v.Buffer())
+		cli.blurConsole(v)
+		if line == "" {
+			return
+		}
+
+		cli.history = append(cli.history, line)
+		cli.historyPos = len(cli.history)
+		cli.printf(":%s", line)
+		if err := cli.Exec(line); err != nil {
+			cli.printf("\x1b[31m%s\x1b[0m", err)
+		}
+	})
+	cli.bindView(ViewConsole, gocui.KeyEsc, cli.blurConsole)
+	cli.bindView(ViewConsole, gocui.KeyCtrlC, cli.blurConsole)
+	cli.bindView(ViewConsole, gocui.KeyArrowUp, func(v *gocui.View) {
+		if cli.historyPos > 0 {
+			cli.historyPos--
+			cli.setConsoleLine(v, cli.history[cli.historyPos])
+		}
+	})
+	cli.bindView(ViewConsole, gocui.KeyArrowDown, func(v *gocui.View) {
+		if cli.historyPos < len(cli.history) {
+			cli.historyPos++
+		}
+		if cli.historyPos == len(cli.history) {
+			cli.setConsoleLine(v, "")
+		} else {
+			cli.setConsoleLine(v, cli.history[cli.historyPos])
+		}
+	})
+	cli.bindView(ViewConsole, gocui.KeyTab, cli.complete)
+}
+
+// complete extends the command or symbol name being typed to the longest
+// common prefix of all matches, listing them if there are several.
+func (cli *CLI) complete(v *gocui.View) {
+	line := strings.TrimLeft(strings.TrimRight(v.Buffer(), "\n"), " ")
+	i := strings.LastIndexAny(line, " [(")
+	word := line[i+1:]
+
+	var candidates []string
+	if i < 0 {
+		for _, c := range cli.commands {
+			candidates = append(candidates, c.names...)
+		}
+	} else {
+		candidates = cli.Debugger.symbols.Names()
+	}
+
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return
+	}
+	if len(matches) == 1 {
+		cli.setConsoleLine(v, line[:i+1]+matches[0]+" ")
+		return
+	}
+
+	prefix := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	cli.setConsoleLine(v, line[:i+1]+prefix)
+	if len(matches) > 20 {
+		matches = append(matches[:20], "...")
+	}
+	cli.printf("%s", strings.Join(matches, " "))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// LabelCoverage is how many of the instructions under a label have run.
+// Local labels count towards the label they are in.
+type LabelCoverage struct {
+	Name       string
+	Addr       BankAddr
+	Ran, Total int
+}
+
+// Coverage is which instructions of the rom have run, per the code/data log.
+type Coverage struct {
+	Labels []LabelCoverage // by address
+	Ran    map[BankAddr]bool
+}
+
+// romFileOffset returns the offset of a rom address in the rom file.
+func romFileOffset(addr BankAddr) int {
+	if addr.Addr < 0x4000 {
+		return int(addr.Addr)
+	}
+	return max(int(addr.Bank), 1)*0x4000 + int(addr.Addr) - 0x4000
+}
+
+// Coverage finds the instructions in the rom by following branches like the
+// disasm subcommand does, along with any lines of loaded sources, and checks
+// which of them the code/data log saw executed.
+func (d *Debugger) Coverage() *Coverage {
+	c := &Coverage{Ran: make(map[BankAddr]bool)}
+	r := DisassembleROM(d, d.rom)
+	for off := range r.ins {
+		addr := romBankAddr(off)
+		c.Ran[addr] = d.CDL(addr)&CDLExec != 0
+	}
+	for addr := range d.sources.addrs {
+		if addr.Addr < 0x8000 {
+			c.Ran[addr] = d.CDL(addr)&CDLExec != 0
+		}
+	}
+
+	labels := map[BankAddr]*LabelCoverage{}
+	for addr, ran := range c.Ran {
+		name, at, ok := d.enclosingLabel(addr)
+		if !ok {
+			continue
+		}
+		l := labels[at]
+		if l == nil {
+			l = &LabelCoverage{Name: name, Addr: at}
+			labels[at] = l
+		}
+		l.Total++
+		if ran {
+			l.Ran++
+		}
+	}
+	for _, l := range labels {
+		c.Labels = append(c.Labels, *l)
+	}
+	slices.SortFunc(c.Labels, func(a, b LabelCoverage) int {
+		return romFileOffset(a.Addr) - romFileOffset(b.Addr)
+	})
+	return c
+}
+
+// WriteReport writes the coverage of each label and of the whole rom.
+func (c *Coverage) WriteReport(w io.Writer) {
+	pct := func(ran, total int) float64 { return float64(ran) * 100 / float64(max(total, 1)) }
+	fmt.Fprintf(w, "%6s %11s  %-7s %s\n", "%", "ran/total", "addr", "label")
+	for _, l := range c.Labels {
+		fmt.Fprintf(w, "%5.1f%% %5d/%-5d  %-7s %s\n", pct(l.Ran, l.Total), l.Ran, l.Total, l.Addr, l.Name)
+	}
+	ran := 0
+	for _, r := range c.Ran {
+		ran += tern(r, 1, 0)
+	}
+	fmt.Fprintf(w, "%5.1f%% %5d/%-5d  total\n", pct(ran, len(c.Ran)), ran, len(c.Ran))
+}
+
+// SaveLCOV writes the coverage as an lcov tracefile. Instructions are
+// reported at their source lines where sources are loaded, and otherwise
+// against the rom itself with the rom offset + 1 as the line, so that every
+// label still shows up.
+func (d *Debugger) SaveLCOV(file string, c *Coverage) error {
+	type fileLine struct {
+		file string
+		line int
+	}
+	lineOf := func(addr BankAddr) fileLine {
+		if l, ok := d.SourceLine(addr); ok {
+			return fileLine{l.File, l.Line}
+		}
+		return fileLine{d.romPath, romFileOffset(addr) + 1}
+	}
+
+	lines := map[string]map[int]bool{}
+	for addr, ran := range c.Ran {
+		l := lineOf(addr)
+		if lines[l.file] == nil {
+			lines[l.file] = map[int]bool{}
+		}
+		// a line can assemble to more than one instruction
+		lines[l.file][l.line] = lines[l.file][l.line] || ran
+	}
+	funcs := map[string][]LabelCoverage{}
+	for _, l := range c.Labels {
+		f := lineOf(l.Addr).file
+		funcs[f] = append(funcs[f], l)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	var files []string
+	for name := range lines {
+		files = append(files, name)
+	}
+	slices.Sort(files)
+	for _, name := range files {
+		fmt.Fprintf(w, "TN:\nSF:%s\n", name)
+		hit := 0
+		for _, l := range funcs[name] {
+			fmt.Fprintf(w, "FN:%d,%s\n", lineOf(l.Addr).line, l.Name)
+			fmt.Fprintf(w, "FNDA:%d,%s\n", tern(l.Ran > 0, 1, 0), l.Name)
+			hit += tern(l.Ran > 0, 1, 0)
+		}
+		fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", len(funcs[name]), hit)
+
+		var ns []int
+		for n := range lines[name] {
+			ns = append(ns, n)
+		}
+		slices.Sort(ns)
+		hit = 0
+		for _, n := range ns {
+			ran := lines[name][n]
+			fmt.Fprintf(w, "DA:%d,%d\n", n, tern(ran, 1, 0))
+			hit += tern(ran, 1, 0)
+		}
+		fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(lines[name]), hit)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
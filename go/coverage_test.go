@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	d := loadTestROM(t, map[uint16][]byte{
+		0x0100: {0xc3, 0x50, 0x01}, // jp Main
+		0x0150: {
+			0x3e, 0x01, // ld a, 1
+			0xfe, 0x02, // .loop: cp 2
+			0x28, 0x02, // jr z, Unused
+			0x18, 0xfa, // jr .loop
+		},
+		0x0158: {
+			0x3c,       // inc a
+			0x18, 0xfd, // jr Unused
+		},
+	})
+	d.symbols.Add(BankAddr{Addr: 0x0150}, "Main")
+	d.symbols.Add(BankAddr{Addr: 0x0152}, "Main.loop")
+	d.symbols.Add(BankAddr{Addr: 0x0158}, "Unused")
+	d.RunFor(1 + 1 + 3*3)
+
+	c := d.Coverage()
+	want := []LabelCoverage{
+		{Name: "Main", Addr: BankAddr{Addr: 0x0150}, Ran: 4, Total: 4},
+		{Name: "Unused", Addr: BankAddr{Addr: 0x0158}, Ran: 0, Total: 2},
+	}
+	if len(c.Labels) != len(want) {
+		t.Fatalf("labels %+v, want %+v", c.Labels, want)
+	}
+	for i, l := range c.Labels {
+		if l != want[i] {
+			t.Errorf("label %d is %+v, want %+v", i, l, want[i])
+		}
+	}
+	if !c.Ran[BankAddr{Addr: 0x0100}] || c.Ran[BankAddr{Addr: 0x0159}] {
+		t.Errorf("ran 0100 %v and 0159 %v, want only 0100", c.Ran[BankAddr{Addr: 0x0100}], c.Ran[BankAddr{Addr: 0x0159}])
+	}
+
+	file := filepath.Join(t.TempDir(), "coverage.info")
+	if err := d.SaveLCOV(file, c); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lcov := string(b)
+	// without sources, lines are rom offsets + 1
+	for _, s := range []string{
+		"TN:\nSF:" + d.romPath + "\n",
+		"FN:337,Main\nFNDA:1,Main\nFN:345,Unused\nFNDA:0,Unused\nFNF:2\nFNH:1\n",
+		"DA:257,1\n",
+		"DA:337,1\nDA:339,1\nDA:341,1\nDA:343,1\nDA:345,0\nDA:346,0\n",
+	} {
+		if !strings.Contains(lcov, s) {
+			t.Errorf("lcov is missing %q:\n%s", s, lcov)
+		}
+	}
+	ran := 0
+	for _, r := range c.Ran {
+		ran += tern(r, 1, 0)
+	}
+	if end := fmt.Sprintf("LF:%d\nLH:%d\nend_of_record\n", len(c.Ran), ran); !strings.HasSuffix(lcov, end) {
+		t.Errorf("lcov doesn't end the record:\n%s", lcov)
+	}
+}
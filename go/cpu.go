@@ -9,6 +9,7 @@ import (
 
 var (
 	ErrBreak = errors.New("break")
+	ErrWatch = errors.New("watchpoint")
 )
 
 type CPU struct {
@@ -51,8 +52,9 @@ func (z *CPU) Step() error {
 	return nil
 }
 
+// Read does not trigger watchpoints.
 func (z *CPU) Read(addr C.ushort) byte {
-	return byte(C.cpu_read(&z.CPU, addr))
+	return byte(C.cpu_peek(&z.CPU, addr))
 }
 
 type tileMode C.ushort
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// dapMain implements the dap subcommand, which serves the Debug Adapter
+// Protocol on stdio or, with -listen, to one client at a time.
+func dapMain(args []string) error {
+	fs := flag.NewFlagSet("dap", flag.ExitOnError)
+	listen := fs.String("listen", "", "serve on this address instead of stdio")
+	fs.Parse(args)
+
+	if *listen == "" {
+		return ServeDAP(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout})
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("dap: listening on %s", l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		log.Printf("dap: client %s connected", conn.RemoteAddr())
+		if err := ServeDAP(conn); err != nil {
+			log.Printf("dap: %v", err)
+		}
+		conn.Close()
+	}
+}
+
+// ServeDAP runs one debug session on rw. The rom is loaded by the launch
+// request.
+func ServeDAP(rw io.ReadWriter) error {
+	s := &dapServer{
+		d:   NewDebugger(),
+		r:   bufio.NewReader(rw),
+		w:   rw,
+		bps: make(map[string][]BankAddr),
+	}
+	// breakpoints come from the editor
+	s.d.ClearBreakpoint(BankAddr{Addr: 0xc000})
+	return s.serve()
+}
+
+type dapMessage struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command,omitempty"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type dapResponse struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+const dapThread = 1
+
+type dapServer struct {
+	d *Debugger
+	r *bufio.Reader
+
+	mu  sync.Mutex // guards w and seq, events are sent from other goroutines
+	w   io.Writer
+	seq int
+
+	paused      atomic.Bool
+	stopOnEntry bool
+	stopSerial  func()
+	bps         map[string][]BankAddr // by source file
+}
+
+// serve reads requests in the background so that pause can interrupt a
+// request that is running the cpu.
+func (s *dapServer) serve() error {
+	read := make(chan dapMessage)
+	reqs := make(chan dapMessage)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(read)
+		errs <- s.read(read, done)
+	}()
+
+	// requests sent during a run queue up here, so that reading never waits
+	// for the run and always sees a pause
+	go func() {
+		defer close(reqs)
+		in := read
+		var queue []dapMessage
+		for in != nil || len(queue) > 0 {
+			var out chan<- dapMessage
+			var next dapMessage
+			if len(queue) > 0 {
+				out, next = reqs, queue[0]
+			}
+			select {
+			case req, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				queue = append(queue, req)
+			case out <- next:
+				queue = queue[1:]
+			case <-done:
+				return
+			}
+		}
+	}()
+	defer func() {
+		if s.stopSerial != nil {
+			s.stopSerial()
+		}
+	}()
+
+	for req := range reqs {
+		if s.handle(req) {
+			return nil
+		}
+	}
+	return <-errs
+}
+
+func (s *dapServer) read(reqs chan<- dapMessage, done <-chan struct{}) error {
+	for {
+		data, err := readDAPMessage(s.r)
+		if err != nil {
+			return err
+		}
+		var req dapMessage
+		if err := json.Unmarshal(data, &req); err != nil {
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+
+		switch req.Command {
+		case "pause":
+			s.paused.Store(true)
+			s.d.Interrupt()
+		case "continue", "next", "stepIn", "stepOut", "configurationDone":
+			// only a pause sent after this may interrupt it
+			s.paused.Store(false)
+			s.d.interrupt.Store(false)
+		}
+		select {
+		case reqs <- req:
+		case <-done:
+			return nil
+		}
+	}
+}
+
+func readDAPMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(k, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return nil, fmt.Errorf("bad header %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length")
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+func (s *dapServer) send(msg func(seq int) any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	data, err := json.Marshal(msg(s.seq))
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err = s.w.Write(data)
+	return err
+}
+
+func (s *dapServer) respond(req dapMessage, body any, err error) {
+	s.send(func(seq int) any {
+		r := dapResponse{Seq: seq, Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+		if err != nil {
+			r.Message = err.Error()
+		}
+		return r
+	})
+}
+
+func (s *dapServer) event(event string, body any) {
+	s.send(func(seq int) any {
+		return dapEvent{Seq: seq, Type: "event", Event: event, Body: body}
+	})
+}
+
+// handle answers req and reports whether the session is over.
+func (s *dapServer) handle(req dapMessage) bool {
+	d := s.d
+	args := func(v any) error {
+		if len(req.Arguments) == 0 {
+			return nil
+		}
+		return json.Unmarshal(req.Arguments, v)
+	}
+
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]bool{
+			"supportsConfigurationDoneRequest":  true,
+			"supportsConditionalBreakpoints":    true,
+			"supportsHitConditionalBreakpoints": true,
+			"supportsReadMemoryRequest":         true,
+			"supportsTerminateRequest":          true,
+		}, nil)
+
+	case "launch":
+		var a struct {
+			Program     string `json:"program"`
+			Sym         string `json:"sym"`
+			Sources     string `json:"sources"`
+			StopOnEntry bool   `json:"stopOnEntry"`
+		}
+		err := args(&a)
+		if err == nil {
+			err = s.launch(a.Program, a.Sym, a.Sources)
+		}
+		s.stopOnEntry = a.StopOnEntry
+		s.respond(req, nil, err)
+		if err == nil {
+			s.event("initialized", nil)
+		}
+
+	case "setBreakpoints":
+		var a struct {
+			Source      dapSource `json:"source"`
+			Breakpoints []struct {
+				Line         int    `json:"line"`
+				Condition    string `json:"condition"`
+				HitCondition string `json:"hitCondition"`
+			} `json:"breakpoints"`
+		}
+		if err := args(&a); err != nil {
+			s.respond(req, nil, err)
+			break
+		}
+		type breakpoint struct {
+			Verified bool   `json:"verified"`
+			Line     int    `json:"line"`
+			Message  string `json:"message,omitempty"`
+		}
+		bps := []breakpoint{}
+		s.clearBreakpoints(a.Source.Path)
+		for _, b := range a.Breakpoints {
+			addr, err := s.setBreakpoint(a.Source.Path, b.Line, b.Condition, b.HitCondition)
+			bp := breakpoint{Verified: err == nil, Line: b.Line}
+			if err != nil {
+				bp.Message = err.Error()
+			} else {
+				s.bps[a.Source.Path] = append(s.bps[a.Source.Path], addr)
+			}
+			bps = append(bps, bp)
+		}
+		s.respond(req, map[string]any{"breakpoints": bps}, nil)
+
+	case "configurationDone":
+		s.respond(req, nil, nil)
+		if s.stopOnEntry {
+			s.stopped("entry", "")
+		} else {
+			d.Run()
+			s.stopped("", "")
+		}
+
+	case "threads":
+		s.respond(req, map[string]any{
+			"threads": []map[string]any{{"id": dapThread, "name": "SM83"}},
+		}, nil)
+
+	case "stackTrace":
+		var a struct {
+			StartFrame int `json:"startFrame"`
+			Levels     int `json:"levels"`
+		}
+		args(&a)
+		frames := s.stackTrace()
+		total := len(frames)
+		frames = frames[min(a.StartFrame, total):]
+		if a.Levels > 0 && a.Levels < len(frames) {
+			frames = frames[:a.Levels]
+		}
+		s.respond(req, map[string]any{"stackFrames": frames, "totalFrames": total}, nil)
+
+	case "scopes":
+		s.respond(req, map[string]any{
+			"scopes": []map[string]any{{"name": "Registers", "presentationHint": "registers", "variablesReference": 1}},
+		}, nil)
+
+	case "variables":
+		s.respond(req, map[string]any{"variables": s.registers()}, nil)
+
+	case "readMemory":
+		var a struct {
+			MemoryReference string `json:"memoryReference"`
+			Offset          int    `json:"offset"`
+			Count           int    `json:"count"`
+		}
+		if err := args(&a); err != nil {
+			s.respond(req, nil, err)
+			break
+		}
+		body, err := s.readMemory(a.MemoryReference, a.Offset, a.Count)
+		s.respond(req, body, err)
+
+	case "continue":
+		s.respond(req, map[string]bool{"allThreadsContinued": true}, nil)
+		d.Run()
+		s.stopped("", "")
+
+	case "next":
+		s.respond(req, nil, nil)
+		d.StepOver()
+		s.stopped("step", "")
+
+	case "stepIn":
+		s.respond(req, nil, nil)
+		d.StepInto()
+		s.stopped("step", "")
+
+	case "stepOut":
+		s.respond(req, nil, nil)
+		d.StepOut()
+		s.stopped("step", "")
+
+	case "pause":
+		// the cpu was interrupted by read, or was already stopped
+		s.respond(req, nil, nil)
+
+	case "terminate":
+		s.respond(req, nil, nil)
+		s.event("terminated", nil)
+
+	case "disconnect":
+		s.respond(req, nil, nil)
+		return true
+
+	default:
+		s.respond(req, nil, fmt.Errorf("unsupported request %q", req.Command))
+	}
+	return false
+}
+
+// launch loads the rom, its symbols and the sources under dir, which
+// defaults to the directory of the rom.
+func (s *dapServer) launch(rom, sym, dir string) error {
+	d := s.d
+	if rom == "" {
+		return fmt.Errorf("no program to launch")
+	}
+	if err := d.Load(rom); err != nil {
+		return err
+	}
+	if sym != "" {
+		if err := d.LoadSymbols(sym); err != nil {
+			return err
+		}
+	}
+	if dir == "" {
+		dir = filepath.Dir(rom)
+	}
+	if err := d.LoadSources(dir); err != nil {
+		return err
+	}
+	d.EnableRewind(DefaultRewindInstructions, DefaultRewindFrames)
+	s.stopSerial = drainSerial(d, &dapOutput{s: s})
+	return nil
+}
+
+func (s *dapServer) clearBreakpoints(file string) {
+	for _, addr := range s.bps[file] {
+		s.d.ClearBreakpoint(addr)
+	}
+	delete(s.bps, file)
+}
+
+// setBreakpoint breaks at line of file. A hit condition of n breaks on the
+// nth hit.
+func (s *dapServer) setBreakpoint(file string, line int, cond, hits string) (BankAddr, error) {
+	d := s.d
+	if !d.HasSource(file) {
+		if err := d.LoadSource(file); err != nil {
+			return BankAddr{}, err
+		}
+	}
+	addr, ok := d.SourceAddr(file, line)
+	if !ok {
+		return BankAddr{}, fmt.Errorf("no code found for this line")
+	}
+
+	ignore := 0
+	if hits != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(hits), ">=")))
+		if err != nil || n < 1 {
+			return BankAddr{}, fmt.Errorf("bad hit count %q", hits)
+		}
+		ignore = n - 1
+	}
+	return addr, d.SetBreakpoint(addr, cond, ignore)
+}
+
+// stopped tells the client why the cpu stopped. An empty reason is worked
+// out from the state of the debugger.
+func (s *dapServer) stopped(reason, description string) {
+	d := s.d
+	pc := d.BankAddr(d.PC())
+	switch {
+	case reason == "entry":
+	case s.paused.Swap(false):
+		reason = "pause"
+	case d.LastWatch() != nil:
+		reason, description = "data breakpoint", d.LastWatch().String()
+	case reason != "":
+	case d.IsBreakpoint(pc):
+		reason = "breakpoint"
+	default:
+		reason = "pause"
+		if ins := d.Disassemble(pc).Ins; ins.IsJump() && ins.Target == pc.Addr {
+			description = "stuck in a loop"
+		}
+	}
+	s.event("stopped", map[string]any{
+		"reason":            reason,
+		"description":       description,
+		"threadId":          dapThread,
+		"allThreadsStopped": true,
+	})
+}
+
+// stackTrace returns the current location followed by the call sites on the
+// shadow call stack.
+func (s *dapServer) stackTrace() []map[string]any {
+	d := s.d
+	calls := d.CallStack()
+	pc := d.BankAddr(d.PC())
+
+	var frames []map[string]any
+	for i := len(calls); i >= 0; i-- {
+		var name string
+		if i > 0 {
+			name = d.Describe(calls[i-1].To)
+		} else if sym, _, ok := d.symbols.Before(pc); ok {
+			name = sym
+		} else {
+			name = pc.String()
+		}
+
+		frame := map[string]any{
+			"id":                          len(frames) + 1,
+			"name":                        name,
+			"line":                        0,
+			"column":                      0,
+			"instructionPointerReference": pc.String(),
+		}
+		if line, ok := d.SourceLine(pc); ok {
+			frame["source"] = dapSource{Name: filepath.Base(line.File), Path: line.File}
+			frame["line"], frame["column"] = line.Line, 1
+		}
+		frames = append(frames, frame)
+
+		if i > 0 {
+			pc = calls[i-1].From
+		}
+	}
+	return frames
+}
+
+func (s *dapServer) registers() []map[string]any {
+	z := s.d.CPUState()
+	reg8 := func(name string, v uint8) map[string]any {
+		return map[string]any{"name": name, "value": fmt.Sprintf("$%02X", v), "variablesReference": 0}
+	}
+	reg16 := func(name string, v uint16) map[string]any {
+		return map[string]any{"name": name, "value": fmt.Sprintf("$%04X", v), "variablesReference": 0, "memoryReference": fmt.Sprintf("0x%04X", v)}
+	}
+	flag := func(name string, on bool) string { return tern(on, name, "-") }
+	return []map[string]any{
+		reg8("A", z.A),
+		reg8("F", z.F()),
+		reg8("B", z.B),
+		reg8("C", z.C),
+		reg8("D", z.D),
+		reg8("E", z.E),
+		reg8("H", z.H),
+		reg8("L", z.L),
+		reg16("BC", uint16(z.B)<<8|uint16(z.C)),
+		reg16("DE", uint16(z.D)<<8|uint16(z.E)),
+		reg16("HL", uint16(z.H)<<8|uint16(z.L)),
+		reg16("SP", z.SP),
+		reg16("PC", z.PC),
+		{"name": "Flags", "value": flag("Z", z.FZ) + flag("N", z.FN) + flag("H", z.FH) + flag("C", z.FC), "variablesReference": 0},
+		{"name": "IME", "value": strconv.FormatBool(z.IrqEnabled), "variablesReference": 0},
+		{"name": "Halted", "value": strconv.FormatBool(z.Halted), "variablesReference": 0},
+		{"name": "Cycles", "value": strconv.FormatUint(uint64(z.Cycles), 10), "variablesReference": 0},
+	}
+}
+
+// readMemory reads count bytes from offset bytes after ref, which is an
+// address or symbol, stopping at the end of the address space.
+func (s *dapServer) readMemory(ref string, offset, count int) (any, error) {
+	d := s.d
+	addr, err := d.ParseAddr(ref)
+	if err != nil {
+		return nil, err
+	}
+	start := int(addr.Addr) + offset
+	if start < 0 || start > 0xffff {
+		return map[string]any{"address": fmt.Sprintf("0x%X", max(start, 0)), "unreadableBytes": count}, nil
+	}
+
+	addr = d.Offset(addr, offset)
+	n := min(count, 0x10000-start)
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = d.ReadBank(d.Offset(addr, i))
+	}
+	return map[string]any{
+		"address":         fmt.Sprintf("0x%04X", addr.Addr),
+		"data":            base64.StdEncoding.EncodeToString(data),
+		"unreadableBytes": count - n,
+	}, nil
+}
+
+// dapOutput sends serial output to the client a line at a time.
+type dapOutput struct {
+	s    *dapServer
+	line []byte
+}
+
+func (o *dapOutput) Write(p []byte) (int, error) {
+	for _, b := range p {
+		o.line = append(o.line, b)
+		if b == '\n' {
+			o.s.event("output", map[string]string{"category": "stdout", "output": string(o.line)})
+			o.line = o.line[:0]
+		}
+	}
+	return len(p), nil
+}
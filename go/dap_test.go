@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// A pause must get through while a continue is running, even with other
+// requests sent before it that can only be answered once the run stops.
+func TestDAPPauseDuringRun(t *testing.T) {
+	rom := loadTestROM(t, map[uint16][]byte{
+		0x0100: {0x18, 0xfe}, // jr @
+	}).romPath
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go ServeDAP(server)
+
+	msgs := make(chan map[string]any)
+	go func() {
+		r := bufio.NewReader(client)
+		for {
+			data, err := readDAPMessage(r)
+			if err != nil {
+				close(msgs)
+				return
+			}
+			var m map[string]any
+			json.Unmarshal(data, &m)
+			msgs <- m
+		}
+	}()
+	seq := 0
+	send := func(command string, args any) {
+		seq++
+		data, _ := json.Marshal(map[string]any{"seq": seq, "type": "request", "command": command, "arguments": args})
+		if _, err := fmt.Fprintf(client, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait := func(kind, name string) map[string]any {
+		t.Helper()
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case m, ok := <-msgs:
+				if !ok {
+					t.Fatalf("session ended waiting for %s %s", kind, name)
+				}
+				if m["type"] == kind && (m["command"] == name || m["event"] == name) {
+					return m
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %s %s", kind, name)
+			}
+		}
+	}
+
+	send("initialize", map[string]any{})
+	wait("response", "initialize")
+	send("launch", map[string]any{"program": rom})
+	wait("event", "initialized")
+	send("configurationDone", nil)
+	wait("response", "configurationDone")
+
+	send("threads", nil)
+	send("setBreakpoints", map[string]any{"source": map[string]any{"path": rom}})
+	send("pause", nil)
+	stopped := wait("event", "stopped")
+	if reason := stopped["body"].(map[string]any)["reason"]; reason != "pause" {
+		t.Errorf("stopped for %v, want pause", reason)
+	}
+	wait("response", "threads")
+	send("disconnect", nil)
+	wait("response", "disconnect")
+}
@@ -5,35 +5,78 @@ import (
 	"image"
 	"image/color"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 )
 import "C"
 
 type Debugger struct {
 	Z *CPU
 
-	breakpoints map[uint16]bool
+	breakpoints map[BankAddr]*Breakpoint
 	cpuState    CPUState
+	cpuValid    bool
+	romPath     string
+	romFile     []byte // as read from disk
+	rom         []byte // with any patches given to Load applied
 	romBanks    []*C.uchar
 	romBank     C.uchar
-	dasmCache   map[uint16]Dasm
+	cdlBanks    []*C.uchar
+	cdlRAM      *C.uchar
+	dasmCache   map[BankAddr]Dasm
+	symbols     *Symbols
+	sources     *SourceMap
+	patches     []*Patch
+	cheats      []*Cheat
+
+	watchpoints []*Watchpoint
+	watchPtr    *C.uchar
+	watchFlags  []C.uchar
+	lastWatch   *WatchHit
+	executed    bool // whether the last step ran an instruction
+
+	memPrev   memSnapshot
+	callStack []Frame
+	pushes    map[uint16]string
+	trace     *tracer
+	profile   *profiler
+	profiling bool
+	rewind    *rewinder
+	interrupt atomic.Bool
 }
 
 func NewDebugger() *Debugger {
 	return &Debugger{
 		Z: NewCPU(),
-		breakpoints: map[uint16]bool{
-			0xc000: true,
+		breakpoints: map[BankAddr]*Breakpoint{
+			{Addr: 0xc000}: {Addr: BankAddr{Addr: 0xc000}},
 		},
-		dasmCache: make(map[uint16]Dasm),
+		dasmCache: make(map[BankAddr]Dasm),
+		symbols:   NewSymbols(),
+		sources:   NewSourceMap(),
+		pushes:    make(map[uint16]string),
 	}
 }
 
-func (d *Debugger) Load(file string) error {
+// Load loads a rom, applying the IPS, UPS or BPS patches given in order. With
+// none, patches named after the rom such as game.ips are applied.
+func (d *Debugger) Load(file string, patches ...string) error {
 	bytes, err := ioutil.ReadFile(file)
 	if err != nil {
 		return err
 	}
+	d.romPath, d.romFile = file, bytes
+	if len(patches) == 0 {
+		patches = findROMPatches(file)
+	}
+	for _, patch := range patches {
+		if bytes, err = ApplyROMPatch(bytes, patch); err != nil {
+			return err
+		}
+	}
+	d.rom = bytes
 
 	var count = len(bytes) / 0x4000
 	if len(bytes)%0x4000 != 0 {
@@ -54,11 +97,30 @@ func (d *Debugger) Load(file string) error {
 	}
 
 	d.Z.CPU.rom = d.romBanks[0]
+	d.callStack = nil
+	clear(d.pushes)
+	d.sources = NewSourceMap()
+	d.patches = nil
+	if d.rewind != nil {
+		d.EnableRewind(len(d.rewind.deltas.buf), len(d.rewind.states.buf))
+	}
 	if len(d.romBanks) > int(d.Z.CPU.xrom_bank) {
 		d.Z.CPU.xrom = d.romBanks[d.Z.CPU.xrom_bank]
 		d.romBank = 1
 	}
+	d.enableCDL()
+
+	d.cheats = nil
+	if err := d.loadCheats(cheatFile(file)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	d.syncCheats()
 
+	// pick up symbols generated alongside the rom, e.g. by rgblink -n
+	sym := strings.TrimSuffix(file, filepath.Ext(file)) + ".sym"
+	if _, err := os.Stat(sym); err == nil {
+		return d.LoadSymbols(sym)
+	}
 	return nil
 }
 
@@ -70,9 +132,13 @@ type CPUState struct {
 	Halted, Stopped, IrqEnabled bool
 }
 
+func (z CPUState) F() uint8 {
+	return uint8(flagBit(z.FZ)<<7 | flagBit(z.FN)<<6 | flagBit(z.FH)<<5 | flagBit(z.FC)<<4)
+}
+
 func (d *Debugger) CPUState() CPUState {
 	z := d.Z.CPU
-	if uint32(z.cycles) == d.cpuState.Cycles {
+	if d.cpuValid && uint32(z.cycles) == d.cpuState.Cycles {
 		return d.cpuState
 	}
 
@@ -95,30 +161,140 @@ func (d *Debugger) CPUState() CPUState {
 		Stopped:    z.stopped > 0,
 		IrqEnabled: z.irq_enabled > 0,
 	}
+	d.cpuValid = true
 	return d.cpuState
 }
 
+// SetRegister writes an 8-bit (a f b c d e h l) or 16-bit (af bc de hl sp pc)
+// register.
+func (d *Debugger) SetRegister(name string, value int) error {
+	z := &d.Z.CPU
+	regs8 := map[string]*C.uchar{"a": &z.a, "f": &z.f, "b": &z.b, "c": &z.c, "d": &z.d, "e": &z.e, "h": &z.h, "l": &z.l}
+	regs16 := map[string][2]*C.uchar{"af": {&z.a, &z.f}, "bc": {&z.b, &z.c}, "de": {&z.d, &z.e}, "hl": {&z.h, &z.l}}
+
+	name = strings.ToLower(name)
+	if r, ok := regs8[name]; ok {
+		if value < 0 || value > 0xff {
+			return fmt.Errorf("%s: %d out of range", name, value)
+		}
+		*r = C.uchar(value)
+	} else if value < 0 || value > 0xffff {
+		return fmt.Errorf("%s: %d out of range", name, value)
+	} else if r, ok := regs16[name]; ok {
+		*r[0], *r[1] = C.uchar(value>>8), C.uchar(value)
+	} else if name == "sp" {
+		z.sp = C.ushort(value)
+	} else if name == "pc" {
+		z.pc = C.ushort(value)
+	} else {
+		return fmt.Errorf("unknown register %q", name)
+	}
+
+	// lower nibble of f is always 0
+	z.f &= 0xf0
+	d.InvalidateCPUState()
+	return nil
+}
+
+// SetFlag sets or clears a flag (fz fn fh fc) or cpu state (ime halted
+// stopped).
+func (d *Debugger) SetFlag(name string, on bool) error {
+	z := &d.Z.CPU
+	bit := C.uchar(tern(on, 1, 0))
+	switch strings.ToLower(name) {
+	case "fz":
+		z.f = z.f&^(1<<7) | bit<<7
+	case "fn":
+		z.f = z.f&^(1<<6) | bit<<6
+	case "fh":
+		z.f = z.f&^(1<<5) | bit<<5
+	case "fc":
+		z.f = z.f&^(1<<4) | bit<<4
+	case "ime":
+		z.irq_enabled = bit
+	case "halted":
+		z.halted = bit
+	case "stopped":
+		z.stopped = bit
+	default:
+		return fmt.Errorf("unknown flag %q", name)
+	}
+	d.InvalidateCPUState()
+	return nil
+}
+
+// InvalidateCPUState must be called after writing to the cpu directly.
+func (d *Debugger) InvalidateCPUState() {
+	d.cpuValid = false
+	d.InvalidateDasmCache()
+}
+
+// decode decodes the instruction at addr straight from memory. Unlike
+// Disassemble it sees code in ram that has changed during a run.
+func (d *Debugger) decode(addr BankAddr) Instruction {
+	return Decode(addr.Addr, []byte{d.ReadBank(addr), d.ReadBank(d.Offset(addr, 1)), d.ReadBank(d.Offset(addr, 2))})
+}
+
 func (d *Debugger) step() error {
+	pc := d.BankAddr(d.PC())
+	ins := d.decode(pc)
+	sp := uint16(d.Z.CPU.sp)
+	halted := d.Z.CPU.halted != 0
+	d.Z.CPU.watch_len = 0
+	d.lastWatch = nil
+	if d.trace != nil {
+		d.traceStep(pc)
+	}
+	if d.rewind != nil {
+		d.rewindBefore()
+	}
+	stack, cycles := d.callStack, d.Z.CPU.cycles
+
 	err := d.Z.Step()
-	// check rom/ram banks
-	if d.Z.CPU.xrom_bank != d.romBank {
+	// idling in halt and dispatching an interrupt don't run an instruction
+	idle := halted && d.Z.CPU.halted != 0
+	d.executed = d.Z.CPU.irq_vector == 0 && !idle
+	if d.trace != nil && d.executed {
+		d.traceExecuted()
+	}
+	d.syncBanks()
+	d.trackPushes(ins, sp)
+	d.trackCalls(pc, ins, sp)
+	if d.profiling {
+		d.profileStep(pc, stack, idle, uint32(d.Z.CPU.cycles-cycles))
+	}
+	if d.rewind != nil {
+		d.rewindAfter()
+	}
+
+	if d.checkWatch(pc) {
+		err = ErrWatch
+	}
+	return err
+}
+
+// syncBanks maps in the rom bank selected by the MBC.
+func (d *Debugger) syncBanks() {
+	if d.Z.CPU.xrom_bank != d.romBank && int(d.Z.CPU.xrom_bank) < len(d.romBanks) {
 		d.romBank = d.Z.CPU.xrom_bank
 		d.Z.CPU.xrom = d.romBanks[d.romBank]
+		d.Z.CPU.cdl_xrom = d.cdlBanks[d.romBank]
 	}
-	return err
 }
 
 func (d *Debugger) StepInto() {
+	d.snapshot()
 	d.step()
 	d.InvalidateDasmCache()
 }
 
 func (d *Debugger) StepOver() {
-	if isCall(d.PCBytes()[0]) {
-		next := d.NextAddr(d.PC())
-		for d.PC() != next {
+	d.snapshot()
+	if d.Disassemble(d.BankAddr(d.PC())).Ins.IsCall() {
+		next := d.NextAddr(d.BankAddr(d.PC()))
+		for d.BankAddr(d.PC()) != next {
 			err := d.step()
-			if err == ErrBreak || d.breakpoints[d.PC()] {
+			if d.stop(err) {
 				break
 			}
 		}
@@ -128,84 +304,162 @@ func (d *Debugger) StepOver() {
 	d.InvalidateDasmCache()
 }
 
+// StepOut runs until a return pops the innermost frame of the call stack.
+// Returns from calls and interrupts made meanwhile, including recursive
+// calls, push and pop frames of their own so are skipped. With no frame, as
+// when the call happened before the debugger was tracking, it stops at the
+// first return above the stack pointer at the start.
+func (d *Debugger) StepOut() {
+	d.snapshot()
+	depth, sp := len(d.callStack), uint16(d.Z.CPU.sp)
+	for {
+		ret := d.decode(d.BankAddr(d.PC())).IsReturn()
+		err := d.step()
+		out := tern(depth > 0, len(d.callStack) < depth, uint16(d.Z.CPU.sp) > sp)
+		if ret && out || d.stop(err) {
+			break
+		}
+	}
+	d.InvalidateDasmCache()
+}
+
+// RunTo runs until addr is reached as if it had a breakpoint.
+func (d *Debugger) RunTo(addr BankAddr) {
+	d.snapshot()
+	for {
+		err := d.step()
+		if d.BankAddr(d.PC()) == addr || d.stop(err) {
+			break
+		}
+	}
+	d.InvalidateDasmCache()
+}
+
 func (d *Debugger) Run() {
+	d.snapshot()
 	for {
 		err := d.step()
-		if err == ErrBreak || d.breakpoints[d.PC()] {
+		if d.stop(err) {
 			break
 		}
 	}
 	d.InvalidateDasmCache()
 }
 
+// RunFor runs n instructions, or until stopped if n is 0, ignoring
+// breakpoints. Time spent in halt and dispatching interrupts doesn't count.
+// It returns the number of instructions run.
+func (d *Debugger) RunFor(n int) (int, error) {
+	d.snapshot()
+	defer d.InvalidateDasmCache()
+	for i := 0; n == 0 || i < n; {
+		err := d.step()
+		if d.executed {
+			i++
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+	return n, nil
+}
+
+// RunToCycle runs until the cycle count reaches cycle, ignoring breakpoints.
+func (d *Debugger) RunToCycle(cycle uint32) error {
+	d.snapshot()
+	defer d.InvalidateDasmCache()
+	for uint32(d.Z.CPU.cycles) < cycle {
+		if err := d.step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stop reports whether a run should stop after a step returned err.
+func (d *Debugger) stop(err error) bool {
+	return err != nil || d.interrupt.Swap(false) || d.hitBreakpoint(d.BankAddr(d.PC()))
+}
+
+// Interrupt stops a run in progress. It is safe to call from any goroutine.
+func (d *Debugger) Interrupt() {
+	d.interrupt.Store(true)
+}
+
 func (d *Debugger) PC() uint16 {
 	return uint16(d.Z.CPU.pc)
 }
 
 func (d *Debugger) PCBytes() []byte {
-	return d.Disassemble(d.PC()).Bytes
+	return d.Disassemble(d.BankAddr(d.PC())).Bytes
 }
 
 func (d *Debugger) Read(addr uint16) byte {
 	return d.Z.Read(C.ushort(addr))
 }
 
-func (d *Debugger) ToggleBreakpoint(addr uint16) {
-	d.breakpoints[addr] = !d.breakpoints[addr]
-}
-
-func (d *Debugger) IsBreakpoint(addr uint16) bool {
-	return d.breakpoints[addr]
-}
-
-func (d *Debugger) Disassemble(addr uint16) Dasm {
+func (d *Debugger) Disassemble(addr BankAddr) Dasm {
 	if dasm, ok := d.dasmCache[addr]; ok {
 		return dasm
 	}
 
 	var bytes = make([]byte, 3)
-	for j := uint16(0); j < 3; j++ {
-		bytes[j] = d.Z.Read(C.ushort(addr + j))
+	for j := 0; j < 3; j++ {
+		bytes[j] = d.ReadBank(d.Offset(addr, j))
 	}
 
-	decoded, count := decode(bytes)
+	ins := Decode(addr.Addr, bytes)
+	if addr != d.BankAddr(d.PC()) && d.isData(addr, ins) {
+		ins = Instruction{Opcode: uint16(bytes[0]), Mnemonic: "db", Operands: []Operand{{Kind: OperandImm8, Value: int(bytes[0])}}, Length: 1, Flags: "----"}
+	}
+	label, ok := d.symbols.Name(addr)
+	if !ok && addr.Addr < 0x4000 {
+		label, _ = vectorName(addr.Addr)
+	}
 	d.dasmCache[addr] = Dasm{
 		Addr:    addr,
-		Bytes:   bytes[:count],
-		Decoded: decoded,
+		Label:   label,
+		Bytes:   bytes[:ins.Length],
+		Ins:     ins,
+		Decoded: d.symbolize(addr, ins),
 	}
 	return d.dasmCache[addr]
 }
 
-func (d *Debugger) NextAddr(addr uint16) uint16 {
-	return addr + uint16(len(d.Disassemble(addr).Bytes))
+func (d *Debugger) NextAddr(addr BankAddr) BankAddr {
+	return d.Offset(addr, len(d.Disassemble(addr).Bytes))
 }
 
 // PrevAddr returns the first valid address that, after execution, results
 // in PC being set to addr. If there is no instruction that can do so, addr is
 // returned.
-func (d *Debugger) PrevAddr(addr uint16) uint16 {
+func (d *Debugger) PrevAddr(addr BankAddr) BankAddr {
+	// logged instructions and data are known to be correct
+	for i := 1; i <= 3; i++ {
+		prev := d.Offset(addr, -i)
+		if f := d.CDL(prev); (f&CDLExec != 0 || f&(CDLRead|CDLOperand) == CDLRead) && len(d.Disassemble(prev).Bytes) == i {
+			return prev
+		}
+	}
+
 	// check cache directly for already disassembled addresses first, because it
 	// is likely these are correct addresses
-	if dasm, ok := d.dasmCache[addr-1]; ok && len(dasm.Bytes) == 1 {
-		return addr - 1
-	} else if dasm, ok := d.dasmCache[addr-2]; ok && len(dasm.Bytes) == 2 {
-		return addr - 2
-	} else if dasm, ok := d.dasmCache[addr-3]; ok && len(dasm.Bytes) == 3 {
-		return addr - 3
+	for i := 1; i <= 3; i++ {
+		if dasm, ok := d.dasmCache[d.Offset(addr, -i)]; ok && len(dasm.Bytes) == i {
+			return d.Offset(addr, -i)
+		}
 	}
 
 	// if no previous address was previously disassembled, search for the
 	// earliest valid address. DO NOT store these in the cache, because they
 	// might not be valid.
 	var bytes = make([]byte, 3)
-	for j := uint16(0); j < 3; j++ {
-		bytes[j] = d.Z.Read(C.ushort(addr + j - 3))
+	for j := 0; j < 3; j++ {
+		bytes[j] = d.ReadBank(d.Offset(addr, j-3))
 	}
 	for i := 3; i > 0; i-- {
-		_, count := decode(bytes[:i])
-		if count == i {
-			return addr - uint16(i)
+		if Decode(addr.Addr-uint16(i), bytes[3-i:]).Length == i {
+			return d.Offset(addr, -i)
 		}
 	}
 
@@ -213,18 +467,20 @@ func (d *Debugger) PrevAddr(addr uint16) uint16 {
 }
 
 func (d *Debugger) InvalidateDasmCache() {
-	d.dasmCache = make(map[uint16]Dasm)
+	d.dasmCache = make(map[BankAddr]Dasm)
 }
 
 type Dasm struct {
-	Addr    uint16
+	Addr    BankAddr
+	Label   string
 	Bytes   []byte
+	Ins     Instruction
 	Decoded string
 }
 
 func (d *Dasm) String() string {
 	var sb strings.Builder
-	fmt.Fprintf(&sb, "%04X ", d.Addr)
+	fmt.Fprintf(&sb, "%-7s ", d.Addr)
 	for i := 0; i < 3; i++ {
 		if i < len(d.Bytes) {
 			fmt.Fprintf(&sb, "%02X ", d.Bytes[i])
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadTestROM loads a 32K rom with code placed at the given addresses.
+func loadTestROM(t *testing.T, code map[uint16][]byte) *Debugger {
+	t.Helper()
+	rom := make([]byte, 0x8000)
+	for addr, b := range code {
+		copy(rom[addr:], b)
+	}
+	file := filepath.Join(t.TempDir(), "test.gb")
+	if err := os.WriteFile(file, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDebugger()
+	if err := d.Load(file); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestStepOut(t *testing.T) {
+	d := loadTestROM(t, map[uint16][]byte{
+		0x0100: {0xc3, 0x50, 0x01}, // jp $0150
+		0x0150: {
+			0xcd, 0x00, 0x02, // call F
+			0x18, 0xfe, // jr @
+		},
+		0x0200: { // F:
+			0xc5,             // push bc
+			0xc1,             // pop bc
+			0xcd, 0x00, 0x03, // call G
+			0xc9, // ret
+		},
+		0x0300: {0xc9}, // G: ret
+	})
+	// stop after the push, so G returns above the stack pointer at the start
+	d.RunFor(3)
+	d.StepOut()
+	if pc := d.PC(); pc != 0x0153 {
+		t.Errorf("stepped out to %04x, want 0153", pc)
+	}
+}
+
+func TestRunForHalt(t *testing.T) {
+	d := loadTestROM(t, map[uint16][]byte{
+		0x0050: {0xd9},             // reti
+		0x0100: {0xc3, 0x50, 0x01}, // jp $0150
+		0x0150: {
+			0x3e, 0x04, // ld a, IEF_TIMER
+			0xe0, 0xff, // ldh [rIE], a
+			0x3e, 0x05, // ld a, TACF_START | TACF_262KHZ
+			0xe0, 0x07, // ldh [rTAC], a
+			0xfb,       // ei
+			0x76,       // .loop: halt
+			0x18, 0xfd, // jr .loop
+		},
+	})
+	start := d.Z.CPU.cycles
+	if n, err := d.RunFor(30); n != 30 || err != nil {
+		t.Fatalf("RunFor(30) = %d, %v", n, err)
+	}
+	// 30 instructions take at most 6 cycles each, the rest is waiting in halt
+	if cycles := d.Z.CPU.cycles - start; cycles < 1000 {
+		t.Errorf("ran for %d cycles, want the time in halt to not count", cycles)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Expr is a compiled debugger expression. Expressions are C-like and operate
+// on ints. Available operands are registers (a f b c d e h l af bc de hl sp
+// pc), flags (fz fn fh fc ime halted stopped, 0 or 1), the current rom bank
+// (bank), memory reads ([addr]), numbers ($ff, 0xff, 255) and, when parsed by
+// a Debugger, symbol addresses.
+type Expr struct {
+	src  string
+	eval exprFunc
+}
+
+type exprFunc func(d *Debugger) int
+
+func (d *Debugger) ParseExpr(src string) (*Expr, error) {
+	p := exprParser{src: src, symbols: d.symbols}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	eval, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q in %q", p.toks[p.pos], src)
+	}
+	return &Expr{src: src, eval: eval}, nil
+}
+
+func (e *Expr) Eval(d *Debugger) int {
+	return e.eval(d)
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+func flagBit(b bool) int {
+	return tern(b, 1, 0)
+}
+
+var exprIdents = map[string]exprFunc{
+	"a":       func(d *Debugger) int { return int(d.CPUState().A) },
+	"f":       func(d *Debugger) int { return int(d.CPUState().F()) },
+	"b":       func(d *Debugger) int { return int(d.CPUState().B) },
+	"c":       func(d *Debugger) int { return int(d.CPUState().C) },
+	"d":       func(d *Debugger) int { return int(d.CPUState().D) },
+	"e":       func(d *Debugger) int { return int(d.CPUState().E) },
+	"h":       func(d *Debugger) int { return int(d.CPUState().H) },
+	"l":       func(d *Debugger) int { return int(d.CPUState().L) },
+	"af":      func(d *Debugger) int { z := d.CPUState(); return int(z.A)<<8 | int(z.F()) },
+	"bc":      func(d *Debugger) int { z := d.CPUState(); return int(z.B)<<8 | int(z.C) },
+	"de":      func(d *Debugger) int { z := d.CPUState(); return int(z.D)<<8 | int(z.E) },
+	"hl":      func(d *Debugger) int { z := d.CPUState(); return int(z.H)<<8 | int(z.L) },
+	"sp":      func(d *Debugger) int { return int(d.CPUState().SP) },
+	"pc":      func(d *Debugger) int { return int(d.CPUState().PC) },
+	"fz":      func(d *Debugger) int { return flagBit(d.CPUState().FZ) },
+	"fn":      func(d *Debugger) int { return flagBit(d.CPUState().FN) },
+	"fh":      func(d *Debugger) int { return flagBit(d.CPUState().FH) },
+	"fc":      func(d *Debugger) int { return flagBit(d.CPUState().FC) },
+	"ime":     func(d *Debugger) int { return flagBit(d.CPUState().IrqEnabled) },
+	"halted":  func(d *Debugger) int { return flagBit(d.CPUState().Halted) },
+	"stopped": func(d *Debugger) int { return flagBit(d.CPUState().Stopped) },
+	"bank":    func(d *Debugger) int { return int(d.romBank) },
+}
+
+// binary operators by precedence, loosest first
+var exprBinaryOps = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+type exprParser struct {
+	src     string
+	toks    []string
+	pos     int
+	symbols *Symbols
+}
+
+func (p *exprParser) tokenize() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t':
+			i++
+		case isIdentChar(ch) || ch == '$':
+			j := i + 1
+			for j < len(s) && isIdentChar(s[j]) {
+				j++
+			}
+			p.toks = append(p.toks, s[i:j])
+			i = j
+		case i+1 < len(s) && slices.Contains(strings.Fields("== != <= >= && || << >>"), s[i:i+2]):
+			p.toks = append(p.toks, s[i:i+2])
+			i += 2
+		case strings.IndexByte("+-*/%&|^!~<>()[]", ch) >= 0:
+			p.toks = append(p.toks, s[i:i+1])
+			i++
+		default:
+			return fmt.Errorf("unexpected %q in %q", ch, p.src)
+		}
+	}
+	return nil
+}
+
+func isIdentChar(ch byte) bool {
+	return ch == '_' || ch == '.' ||
+		(ch >= '0' && ch <= '9') ||
+		(ch >= 'a' && ch <= 'z') ||
+		(ch >= 'A' && ch <= 'Z')
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) expect(tok string) error {
+	if p.peek() != tok {
+		return fmt.Errorf("expected %q in %q", tok, p.src)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) parseBinary(level int) (exprFunc, error) {
+	if level == len(exprBinaryOps) {
+		return p.parseUnary()
+	}
+
+	lhs, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		found := false
+		for _, o := range exprBinaryOps[level] {
+			found = found || o == op
+		}
+		if !found {
+			return lhs, nil
+		}
+		p.pos++
+
+		rhs, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		lhs = binaryOp(op, lhs, rhs)
+	}
+}
+
+func binaryOp(op string, l, r exprFunc) exprFunc {
+	switch op {
+	case "||":
+		return func(d *Debugger) int { return flagBit(l(d) != 0 || r(d) != 0) }
+	case "&&":
+		return func(d *Debugger) int { return flagBit(l(d) != 0 && r(d) != 0) }
+	case "|":
+		return func(d *Debugger) int { return l(d) | r(d) }
+	case "^":
+		return func(d *Debugger) int { return l(d) ^ r(d) }
+	case "&":
+		return func(d *Debugger) int { return l(d) & r(d) }
+	case "==":
+		return func(d *Debugger) int { return flagBit(l(d) == r(d)) }
+	case "!=":
+		return func(d *Debugger) int { return flagBit(l(d) != r(d)) }
+	case "<":
+		return func(d *Debugger) int { return flagBit(l(d) < r(d)) }
+	case "<=":
+		return func(d *Debugger) int { return flagBit(l(d) <= r(d)) }
+	case ">":
+		return func(d *Debugger) int { return flagBit(l(d) > r(d)) }
+	case ">=":
+		return func(d *Debugger) int { return flagBit(l(d) >= r(d)) }
+	case "<<":
+		return func(d *Debugger) int { return l(d) << (r(d) & 0x1f) }
+	case ">>":
+		return func(d *Debugger) int { return l(d) >> (r(d) & 0x1f) }
+	case "+":
+		return func(d *Debugger) int { return l(d) + r(d) }
+	case "-":
+		return func(d *Debugger) int { return l(d) - r(d) }
+	case "*":
+		return func(d *Debugger) int { return l(d) * r(d) }
+	case "/":
+		return func(d *Debugger) int {
+			if rv := r(d); rv != 0 {
+				return l(d) / rv
+			}
+			return 0
+		}
+	case "%":
+		return func(d *Debugger) int {
+			if rv := r(d); rv != 0 {
+				return l(d) % rv
+			}
+			return 0
+		}
+	}
+	panic("unknown operator " + op)
+}
+
+func (p *exprParser) parseUnary() (exprFunc, error) {
+	switch op := p.peek(); op {
+	case "!", "~", "-":
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "!":
+			return func(d *Debugger) int { return flagBit(x(d) == 0) }, nil
+		case "~":
+			return func(d *Debugger) int { return ^x(d) }, nil
+		default:
+			return func(d *Debugger) int { return -x(d) }, nil
+		}
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprFunc, error) {
+	tok := p.peek()
+	p.pos++
+
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of %q", p.src)
+	case "(":
+		x, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case "[":
+		addr, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return func(d *Debugger) int { return int(d.Read(uint16(addr(d)))) }, p.expect("]")
+	}
+
+	if ident, ok := exprIdents[strings.ToLower(tok)]; ok {
+		return ident, nil
+	}
+	if n, err := parseNumber(strings.ToLower(tok)); err == nil {
+		return func(_ *Debugger) int { return n }, nil
+	}
+	if addr, ok := p.symbols.Addr(tok); ok {
+		return func(_ *Debugger) int { return int(addr.Addr) }, nil
+	}
+	if !isIdentChar(tok[0]) {
+		return nil, fmt.Errorf("unexpected %q in %q", tok, p.src)
+	}
+	return nil, fmt.Errorf("unknown identifier %q in %q", tok, p.src)
+}
+
+// parseNumber accepts $ff and 0xff as hex and everything else as decimal.
+func parseNumber(s string) (int, error) {
+	var n int64
+	var err error
+	if strings.HasPrefix(s, "$") {
+		n, err = strconv.ParseInt(s[1:], 16, 32)
+	} else if strings.HasPrefix(s, "0x") {
+		n, err = strconv.ParseInt(s[2:], 16, 32)
+	} else {
+		n, err = strconv.ParseInt(s, 10, 32)
+	}
+	return int(n), err
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestParseExpr(t *testing.T) {
+	d := loadTestROM(t, nil)
+	d.SetRegister("af", 0x1280)
+	d.SetRegister("hl", 0xc123)
+	d.symbols.Add(BankAddr{Addr: 0xc100}, "wLives")
+	d.Write(BankAddr{Addr: 0xc100}, 3, WriteRaw)
+
+	tests := []struct {
+		src  string
+		want int
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"$ff", 0xff},
+		{"0x10", 0x10},
+		{"1 << 4 | 1", 0x11},
+		{"6 & 3 ^ 1", 3},
+		{"3 > 2 == 1", 1},
+		{"-1", -1},
+		{"~0 & $ff", 0xff},
+		{"!0 + !5", 1},
+		{"7 % 3", 1},
+		{"10 / 0", 0},
+		{"a == $12 && fz", 1},
+		{"a == $12 && fc", 0},
+		{"fc || hl == $c123", 1},
+		{"h", 0xc1},
+		{"AF", 0x1280},
+		{"wLives", 0xc100},
+		{"[wLives] - 1", 2},
+		{"[hl - $23]", 3},
+	}
+	for _, tt := range tests {
+		e, err := d.ParseExpr(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if got := e.Eval(d); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.src, got, tt.want)
+		}
+	}
+
+	for _, src := range []string{"", "1 +", "(1", "[1", "1 2", "wDeaths", "1 # 2", "$fg"} {
+		if _, err := d.ParseExpr(src); err == nil {
+			t.Errorf("%q parsed", src)
+		}
+	}
+}
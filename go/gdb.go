@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// gdbRegisters is the register layout used by g, G, p and P. Every register
+// is 16 bits and sent little endian, so g replies with 24 hex digits:
+//
+//	0 af  1 bc  2 de  3 hl  4 sp  5 pc
+var gdbRegisters = []string{"af", "bc", "de", "hl", "sp", "pc"}
+
+// ServeGDB serves the gdb remote serial protocol on l, one client at a time.
+// Addresses are cpu addresses in the currently mapped banks. It returns nil
+// once a client kills the target, or the error from l.Accept.
+func ServeGDB(d *Debugger, l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		log.Printf("gdb: client %s connected", conn.RemoteAddr())
+		s := &gdbServer{d: d, conn: conn}
+		if err := s.serve(); err != nil && err != io.EOF {
+			log.Printf("gdb: %v", err)
+		}
+		conn.Close()
+		if s.killed {
+			return nil
+		}
+	}
+}
+
+type gdbServer struct {
+	d           *Debugger
+	conn        net.Conn
+	noAck       bool
+	killed      bool // the client asked to kill the target, so stop serving
+	interrupted atomic.Bool
+}
+
+// serve reads packets in the background so that ^C can interrupt a continue
+// while the packet is being handled.
+func (s *gdbServer) serve() error {
+	packets := make(chan string)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(packets)
+		errs <- s.read(packets, done)
+	}()
+
+	for pkt := range packets {
+		reply, done := s.handle(pkt)
+		if err := s.send(reply); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+	return <-errs
+}
+
+func (s *gdbServer) read(packets chan<- string, done <-chan struct{}) error {
+	r := bufio.NewReader(s.conn)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch b {
+		case 0x03:
+			s.interrupted.Store(true)
+			s.d.Interrupt()
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return err
+			}
+			var sum [2]byte
+			if _, err := io.ReadFull(r, sum[:]); err != nil {
+				return err
+			}
+			data = data[:len(data)-1]
+			if fmt.Sprintf("%02x", gdbChecksum(data)) != strings.ToLower(string(sum[:])) {
+				if !s.noAck {
+					s.conn.Write([]byte{'-'})
+				}
+				continue
+			}
+			if !s.noAck {
+				s.conn.Write([]byte{'+'})
+			}
+
+			// only a ^C sent after this resume may interrupt it
+			if data != "" && (data[0] == 'c' || data[0] == 's') {
+				s.interrupted.Store(false)
+				s.d.interrupt.Store(false)
+			}
+			select {
+			case packets <- data:
+			case <-done:
+				return nil
+			}
+		}
+		// acks from the client are ignored
+	}
+}
+
+func gdbChecksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+func (s *gdbServer) send(data string) error {
+	_, err := fmt.Fprintf(s.conn, "$%s#%02x", data, gdbChecksum(data))
+	return err
+}
+
+// handle returns the reply to pkt, and whether the client is done.
+func (s *gdbServer) handle(pkt string) (string, bool) {
+	d := s.d
+	if pkt == "" {
+		return "", false
+	}
+
+	switch pkt[0] {
+	case '?':
+		return "S05", false
+	case 'g':
+		var sb strings.Builder
+		for _, r := range gdbRegisters {
+			v := exprIdents[r](d)
+			fmt.Fprintf(&sb, "%02x%02x", v&0xff, v>>8)
+		}
+		return sb.String(), false
+	case 'G':
+		b, err := hex.DecodeString(pkt[1:])
+		if err != nil || len(b) < len(gdbRegisters)*2 {
+			return "E01", false
+		}
+		for i, r := range gdbRegisters {
+			d.SetRegister(r, int(b[i*2])|int(b[i*2+1])<<8)
+		}
+		return "OK", false
+	case 'p':
+		n, err := strconv.ParseUint(pkt[1:], 16, 8)
+		if err != nil || int(n) >= len(gdbRegisters) {
+			return "E01", false
+		}
+		v := exprIdents[gdbRegisters[n]](d)
+		return fmt.Sprintf("%02x%02x", v&0xff, v>>8), false
+	case 'P':
+		reg, value, _ := strings.Cut(pkt[1:], "=")
+		n, err := strconv.ParseUint(reg, 16, 8)
+		b, err2 := hex.DecodeString(value)
+		if err != nil || err2 != nil || int(n) >= len(gdbRegisters) || len(b) != 2 {
+			return "E01", false
+		}
+		d.SetRegister(gdbRegisters[n], int(b[0])|int(b[1])<<8)
+		return "OK", false
+	case 'm':
+		addr, n, _, err := gdbParseRange(pkt[1:])
+		if err != nil {
+			return "E01", false
+		}
+		var sb strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&sb, "%02x", d.Read(addr+uint16(i)))
+		}
+		return sb.String(), false
+	case 'M':
+		addr, n, data, err := gdbParseRange(pkt[1:])
+		b, err2 := hex.DecodeString(data)
+		if err != nil || err2 != nil || len(b) != n {
+			return "E01", false
+		}
+		for i, v := range b {
+			d.Write(d.BankAddr(addr+uint16(i)), v, WriteRaw)
+		}
+		return "OK", false
+	case 'c', 's':
+		if len(pkt) > 1 {
+			addr, err := strconv.ParseUint(pkt[1:], 16, 16)
+			if err != nil {
+				return "E01", false
+			}
+			d.SetRegister("pc", int(addr))
+		}
+		if pkt[0] == 'c' {
+			d.Run()
+		} else {
+			d.StepInto()
+		}
+		return s.stopReply(), false
+	case 'Z', 'z':
+		return s.breakpoint(pkt[0] == 'Z', pkt[1:]), false
+	case 'D':
+		return "OK", true
+	case 'k':
+		s.killed = true
+		return "", true
+	case 'H':
+		return "OK", false
+	case 'q':
+		switch {
+		case strings.HasPrefix(pkt, "qSupported"):
+			return "PacketSize=4000;QStartNoAckMode+", false
+		case pkt == "qAttached":
+			return "1", false
+		case pkt == "qC":
+			return "QC1", false
+		case pkt == "qfThreadInfo":
+			return "m1", false
+		case pkt == "qsThreadInfo":
+			return "l", false
+		}
+	case 'Q':
+		if pkt == "QStartNoAckMode" {
+			s.noAck = true
+			return "OK", false
+		}
+	}
+
+	// unsupported
+	return "", false
+}
+
+func (s *gdbServer) stopReply() string {
+	if s.interrupted.Swap(false) {
+		return "S02"
+	}
+	if w := s.d.LastWatch(); w != nil {
+		kind := tern(w.Kind == WatchRead, "rwatch", "watch")
+		return fmt.Sprintf("T05%s:%x;", kind, w.Addr)
+	}
+	return "S05"
+}
+
+// breakpoint handles Z and z packets. Types 0 and 1 are breakpoints, 2, 3 and
+// 4 are write, read and access watchpoints.
+func (s *gdbServer) breakpoint(insert bool, args string) string {
+	d := s.d
+	parts := strings.Split(args, ",")
+	if len(parts) < 3 {
+		return "E01"
+	}
+	addr, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	size, err := strconv.ParseUint(parts[2], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+
+	var kinds []WatchKind
+	switch parts[0] {
+	case "0", "1":
+		a := d.BankAddr(uint16(addr))
+		if insert {
+			if err := d.SetBreakpoint(a, "", 0); err != nil {
+				return "E01"
+			}
+		} else {
+			d.ClearBreakpoint(a)
+		}
+		return "OK"
+	case "2":
+		kinds = []WatchKind{WatchWrite}
+	case "3":
+		kinds = []WatchKind{WatchRead}
+	case "4":
+		kinds = []WatchKind{WatchRead, WatchWrite}
+	default:
+		return ""
+	}
+
+	start, end := uint16(addr), uint16(addr+max(size, 1)-1)
+	for _, kind := range kinds {
+		if insert {
+			d.AddWatchpoint(start, end, kind, AnyValue)
+			continue
+		}
+		for _, w := range d.Watchpoints() {
+			if w.Start == start && w.End == end && w.Kind == kind {
+				d.RemoveWatchpoint(w)
+				break
+			}
+		}
+	}
+	return "OK"
+}
+
+// gdbParseRange parses addr,length[:data].
+func gdbParseRange(s string) (uint16, int, string, error) {
+	rng, data, _ := strings.Cut(s, ":")
+	a, n, ok := strings.Cut(rng, ",")
+	if !ok {
+		return 0, 0, "", fmt.Errorf("bad range %q", s)
+	}
+	addr, err := strconv.ParseUint(a, 16, 16)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	length, err := strconv.ParseUint(n, 16, 16)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	return uint16(addr), int(length), data, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// gdbClient speaks the remote serial protocol like gdb does, checking acks
+// and checksums.
+type gdbClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *gdbClient) write(s string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, s); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *gdbClient) readByte() byte {
+	c.t.Helper()
+	b, err := c.r.ReadByte()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return b
+}
+
+// exchange sends pkt and returns the reply.
+func (c *gdbClient) exchange(pkt string) string {
+	c.t.Helper()
+	c.write(fmt.Sprintf("$%s#%02x", pkt, gdbChecksum(pkt)))
+	if b := c.readByte(); b != '+' {
+		c.t.Fatalf("%s: got ack %q, want +", pkt, b)
+	}
+	return c.reply(pkt)
+}
+
+func (c *gdbClient) reply(pkt string) string {
+	c.t.Helper()
+	if b := c.readByte(); b != '$' {
+		c.t.Fatalf("%s: reply starts with %q", pkt, b)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	data = data[:len(data)-1]
+	sum := string([]byte{c.readByte(), c.readByte()})
+	if want := fmt.Sprintf("%02x", gdbChecksum(data)); sum != want {
+		c.t.Fatalf("%s: reply %q has checksum %s, want %s", pkt, data, sum, want)
+	}
+	c.write("+")
+	return data
+}
+
+func TestGDB(t *testing.T) {
+	d := loadTestROM(t, map[uint16][]byte{
+		0x0100: {0x00, 0xc3, 0x50, 0x01}, // nop; jp $0150
+		0x0150: {0x3c, 0x18, 0xfd},       // inc a; jr $0150
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	served := make(chan error, 1)
+	go func() { served <- ServeGDB(d, l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	c := &gdbClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	// a bad checksum is nacked and the packet dropped
+	c.write("$g#00")
+	if b := c.readByte(); b != '-' {
+		t.Fatalf("bad checksum: got ack %q, want -", b)
+	}
+
+	tests := []struct {
+		pkt, want string
+	}{
+		{"qSupported:multiprocess+;swbreak+", "PacketSize=4000;QStartNoAckMode+"},
+		{"?", "S05"},
+		// af bc de hl sp pc, each little endian
+		{"G" + "b001" + "3412" + "7856" + "bc9a" + "feff" + "0001", "OK"},
+		{"g", "b001" + "3412" + "7856" + "bc9a" + "feff" + "0001"},
+		{"p1", "3412"},
+		{"M c100,3:0a0b0c", "E01"},
+		{"Mc100,3:0a0b0c", "OK"},
+		{"mc0ff,5", "000a0b0c00"},
+		{"m0100,4", "00c35001"},
+		{"s", "S05"},
+		{"p5", "0101"},
+		{"Z0,151,1", "OK"},
+		{"c", "S05"},
+		{"p5", "5101"},
+		{"p0", "1002"}, // inc a keeps only the carry flag
+		{"z0,151,1", "OK"},
+		{"Z2,c100,1", "OK"},
+		{"z2,c100,1", "OK"},
+		{"vMustReplyEmpty", ""},
+	}
+	for _, tt := range tests {
+		if got := c.exchange(tt.pkt); got != tt.want {
+			t.Fatalf("%s: got %q, want %q", tt.pkt, got, tt.want)
+		}
+	}
+	if len(d.Watchpoints()) != 0 {
+		t.Errorf("z2 left watchpoints %v", d.Watchpoints())
+	}
+
+	// ^C interrupts a continue that would otherwise never stop
+	c.write(fmt.Sprintf("$c#%02x", gdbChecksum("c")))
+	if b := c.readByte(); b != '+' {
+		t.Fatalf("c: got ack %q, want +", b)
+	}
+	c.write("\x03")
+	if got := c.reply("c"); got != "S02" {
+		t.Fatalf("c interrupted: got %q, want S02", got)
+	}
+	if pc := d.PC(); pc != 0x0150 && pc != 0x0151 {
+		t.Errorf("interrupted at %04x, want in the loop", pc)
+	}
+
+	// kill ends the server
+	c.write(fmt.Sprintf("$k#%02x", gdbChecksum("k")))
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("ServeGDB: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("ServeGDB still running after k")
+	}
+}
@@ -1,22 +1,184 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"io"
 	"log"
+	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
 
 	"github.com/jroimartin/gocui"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		var cmd func([]string) error
+		switch os.Args[1] {
+		case "tracediff":
+			cmd = traceDiffMain
+		case "dap":
+			cmd = dapMain
+		case "disasm":
+			cmd = disasmMain
+		case "bps":
+			cmd = bpsMain
+		}
+		if cmd != nil {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	sym := flag.String("sym", "", "symbol file (default: <rom>.sym)")
+	sources := flag.String("sources", "", "map addresses to the rgbds sources under this directory")
+	var patches stringList
+	flag.Var(&patches, "patch", "apply this IPS, UPS or BPS patch, may be repeated (default: <rom>.ips etc.)")
+	headless := flag.Bool("headless", false, "run without a ui until the rom stops")
+	steps := flag.Int("steps", 0, "stop the headless run after this many instructions")
+	trace := flag.String("trace", "", "log cpu state to this file in gameboy-doctor format")
+	traceRange := flag.String("trace-range", "", "only trace instructions in addr-addr")
+	traceBank := flag.String("trace-bank", "", "only trace instructions in this bank")
+	traceCycles := flag.Bool("trace-cycles", false, "append cycle counts to the trace")
+	cdl := flag.String("cdl", "", "merge the code/data log with this file and save it on exit")
+	coverage := flag.String("coverage", "", "write an lcov file of the instructions run here and print coverage per label on exit")
+	profile := flag.String("profile", "", "count cycles per instruction and write a pprof profile here and a report alongside on exit")
+	gdb := flag.String("gdb", "", "serve the gdb remote protocol on this address instead of the ui")
+	rewind := flag.Int("rewind", DefaultRewindInstructions, "instructions that can be stepped back")
+	rewindFrames := flag.Int("rewind-frames", DefaultRewindFrames, "frames that can be rewound")
+	flag.Parse()
+
 	d := NewDebugger()
-	err := d.Load(os.Args[1])
+	err := d.Load(flag.Arg(0), patches...)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *sym != "" {
+		if err := d.LoadSymbols(*sym); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *sources != "" {
+		if err := d.LoadSources(*sources); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *trace != "" {
+		filter, err := ParseTraceFilter(*traceRange, *traceBank)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := d.StartTrace(*trace, filter, *traceCycles); err != nil {
+			log.Fatal(err)
+		}
+	}
+	defer d.StopTrace()
+
+	if *cdl != "" {
+		if err := d.LoadCDL(*cdl); err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := d.SaveCDL(*cdl); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+
+	if *coverage != "" {
+		defer func() {
+			c := d.Coverage()
+			c.WriteReport(os.Stdout)
+			if err := d.SaveLCOV(*coverage, c); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+
+	if *profile != "" {
+		d.StartProfile()
+		defer func() {
+			if err := saveProfile(d, *profile); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+
+	if *headless {
+		drainSerial(d, os.Stdout)
+		n, err := d.RunFor(*steps)
+		log.Printf("stopped after %d instructions at %s: %v", n, d.BankAddr(d.PC()), err)
+		return
+	}
 
+	if *gdb != "" {
+		l, err := net.Listen("tcp", *gdb)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("gdb: listening on %s", l.Addr())
+		drainSerial(d, os.Stdout)
+
+		// return rather than exit so that the deferred saves run: ^C stops
+		// listening once the current client is done, a second ^C quits as usual
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		go func() {
+			<-sig
+			signal.Stop(sig)
+			log.Print("gdb: stopping")
+			l.Close()
+		}()
+		if err := ServeGDB(d, l); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Print(err)
+		}
+		return
+	}
+
+	d.EnableRewind(*rewind, *rewindFrames)
+	if err := runUI(d); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// saveProfile writes the pprof profile to file and the report next to it.
+func saveProfile(d *Debugger, file string) error {
+	if err := d.SaveProfile(file); err != nil {
+		return err
+	}
+	report := strings.TrimSuffix(file, ".gz")
+	report = strings.TrimSuffix(report, filepath.Ext(report)) + ".txt"
+	f, err := os.Create(report)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	d.WriteProfileReport(f, 50)
+	return f.Close()
+}
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func runUI(d *Debugger) error {
 	cli, err := NewCLI(d)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer cli.Close()
 
@@ -30,4 +192,22 @@ func main() {
 	}()
 
 	gui.ShowAndRun()
+	return nil
+}
+
+// drainSerial copies serial output to w until stop is called. The cpu blocks
+// on serial output until it is read, which the cli only does once running.
+func drainSerial(d *Debugger, w io.Writer) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case b := <-d.Z.Serial:
+				w.Write([]byte{b})
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
 }
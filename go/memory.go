@@ -0,0 +1,75 @@
+package main
+
+// #include "../build/libcgoboy.h"
+import "C"
+
+type WriteMode int
+
+const (
+	// WriteCPU writes like the cpu does, including MBC and IO side effects.
+	WriteCPU WriteMode = iota
+	// WriteRaw pokes the backing memory directly, including rom.
+	WriteRaw
+)
+
+func (d *Debugger) Write(addr BankAddr, b byte, mode WriteMode) {
+	z := &d.Z.CPU
+	a := addr.Addr
+
+	if mode == WriteCPU {
+		C.cpu_write(&d.Z.CPU, C.ushort(a), C.uchar(b))
+		d.syncBanks()
+	} else {
+		switch {
+		case a < 0x4000:
+			if len(d.romBanks) > 0 {
+				asSlice(d.romBanks[0], 0x4000)[a] = C.uchar(b)
+			}
+		case a < 0x8000:
+			if int(addr.Bank) < len(d.romBanks) {
+				asSlice(d.romBanks[addr.Bank], 0x4000)[a-0x4000] = C.uchar(b)
+			}
+		case a < 0xa000:
+			z.vram[a-0x8000] = C.uchar(b)
+		case a < 0xc000:
+			if z.xram != nil {
+				asSlice(z.xram, 0x2000)[a-0xa000] = C.uchar(b)
+			}
+		case a < 0xfe00:
+			z.ram[a&0x1fff] = C.uchar(b)
+		default:
+			z.hram[a-0xfe00] = C.uchar(b)
+		}
+	}
+
+	d.InvalidateDasmCache()
+}
+
+// memSnapshot holds the cpu's own ram so changes can be highlighted after
+// stepping.
+type memSnapshot struct {
+	vram [0x2000]C.uchar
+	ram  [0x2000]C.uchar
+	hram [0x200]C.uchar
+}
+
+func (d *Debugger) snapshot() {
+	d.memPrev.vram = d.Z.CPU.vram
+	d.memPrev.ram = d.Z.CPU.ram
+	d.memPrev.hram = d.Z.CPU.hram
+}
+
+// Changed reports whether addr was modified since the last step. Only vram,
+// wram and hram are tracked.
+func (d *Debugger) Changed(addr uint16) bool {
+	z := &d.Z.CPU
+	switch {
+	case addr >= 0x8000 && addr < 0xa000:
+		return z.vram[addr-0x8000] != d.memPrev.vram[addr-0x8000]
+	case addr >= 0xc000 && addr < 0xfe00:
+		return z.ram[addr&0x1fff] != d.memPrev.ram[addr&0x1fff]
+	case addr >= 0xfe00:
+		return z.hram[addr-0xfe00] != d.memPrev.hram[addr-0xfe00]
+	}
+	return false
+}
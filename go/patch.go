@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Patch is an instruction assembled into memory, with the bytes it replaced.
+type Patch struct {
+	Addr     BankAddr
+	Source   string
+	Old, New []byte
+}
+
+func (p *Patch) String() string {
+	return fmt.Sprintf("%s %-16s % X -> % X", p.Addr, p.Source, p.Old, p.New)
+}
+
+// Assemble assembles src as if it were at addr. Symbols can be used as
+// operands.
+func (d *Debugger) Assemble(addr BankAddr, src string) ([]byte, error) {
+	return Assemble(addr.Addr, src, func(name string) (uint16, bool) {
+		a, ok := d.symbols.Addr(name)
+		return a.Addr, ok
+	})
+}
+
+// Patch assembles src over the instruction at addr. The warning is set if
+// the new instruction is longer than the one it overwrites.
+func (d *Debugger) Patch(addr BankAddr, src string) (*Patch, string, error) {
+	bytes, err := d.Assemble(addr, src)
+	if err != nil {
+		return nil, "", err
+	}
+	var warning string
+	if old := d.Disassemble(addr); len(bytes) > len(old.Bytes) {
+		warning = fmt.Sprintf("%s is %d bytes, overwriting %d byte %s", src, len(bytes), len(old.Bytes), old.Decoded)
+	}
+
+	p := &Patch{Addr: addr, Source: strings.TrimSpace(src), New: bytes}
+	d.applyPatch(p)
+	d.patches = append(d.patches, p)
+	return p, warning, nil
+}
+
+func (d *Debugger) applyPatch(p *Patch) {
+	p.Old = make([]byte, len(p.New))
+	for i, b := range p.New {
+		at := d.Offset(p.Addr, i)
+		p.Old[i] = d.ReadBank(at)
+		d.Write(at, b, WriteRaw)
+	}
+}
+
+func (d *Debugger) undoPatch(p *Patch) {
+	for i, b := range p.Old {
+		d.Write(d.Offset(p.Addr, i), b, WriteRaw)
+	}
+}
+
+func (d *Debugger) Patches() []*Patch {
+	return d.patches
+}
+
+// RevertPatch restores the bytes under patch i. Later patches are undone
+// first and then reapplied, so ones that overlap it keep their bytes.
+func (d *Debugger) RevertPatch(i int) error {
+	if i < 0 || i >= len(d.patches) {
+		return fmt.Errorf("no patch %d", i)
+	}
+	for j := len(d.patches) - 1; j >= i; j-- {
+		d.undoPatch(d.patches[j])
+	}
+	later := slices.Clone(d.patches[i+1:])
+	d.patches = d.patches[:i]
+	for _, p := range later {
+		d.applyPatch(p)
+		d.patches = append(d.patches, p)
+	}
+	return nil
+}
+
+// IsPatched reports whether any patch covers addr.
+func (d *Debugger) IsPatched(addr BankAddr) bool {
+	for _, p := range d.patches {
+		for i := range p.New {
+			if d.Offset(p.Addr, i) == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// profileKey is an instruction executed under a particular call stack.
+type profileKey struct {
+	stack int // index into profiler.stacks
+	pc    BankAddr
+}
+
+type profileCount struct {
+	count, cycles int64
+}
+
+// profiler counts instructions and cycles per address and call stack.
+type profiler struct {
+	samples map[profileKey]*profileCount
+	stacks  [][]Frame
+	ids     map[string]int
+
+	// the call stack only changes on calls and returns, so the last one is
+	// kept to skip looking it up every instruction
+	last   []Frame
+	lastID int
+}
+
+// StartProfile starts counting every instruction executed, discarding any
+// earlier profile.
+func (d *Debugger) StartProfile() {
+	d.profiling = true
+	d.profile = &profiler{
+		samples: make(map[profileKey]*profileCount),
+		ids:     make(map[string]int),
+		lastID:  -1,
+	}
+}
+
+// StopProfile stops counting. The profile can still be reported.
+func (d *Debugger) StopProfile() {
+	d.profiling = false
+}
+
+func (d *Debugger) Profiling() bool {
+	return d.profile != nil && d.profiling
+}
+
+// profileStep counts the instruction at pc, run with the call stack before
+// it executed. Steps that run no instruction only add cycles: idling in halt
+// to the halt before pc, and dispatching an interrupt to its vector.
+func (d *Debugger) profileStep(pc BankAddr, stack []Frame, idle bool, cycles uint32) {
+	switch {
+	case idle:
+		pc = d.Offset(pc, -1)
+	case !d.executed:
+		pc, stack = d.BankAddr(d.PC()), d.callStack
+	}
+	p := d.profile
+	if p.lastID < 0 || len(stack) != len(p.last) || len(stack) > 0 && &stack[0] != &p.last[0] {
+		p.last, p.lastID = stack, p.stackID(stack)
+	}
+	key := profileKey{p.lastID, pc}
+	c := p.samples[key]
+	if c == nil {
+		c = &profileCount{}
+		p.samples[key] = c
+	}
+	if d.executed {
+		c.count++
+	}
+	c.cycles += int64(cycles)
+}
+
+func (p *profiler) stackID(stack []Frame) int {
+	var key []byte
+	for _, f := range stack {
+		key = fmt.Appendf(key, "%s>%s,", f.From, f.To)
+	}
+	if id, ok := p.ids[string(key)]; ok {
+		return id
+	}
+	p.stacks = append(p.stacks, slices.Clone(stack))
+	p.ids[string(key)] = len(p.stacks) - 1
+	return len(p.stacks) - 1
+}
+
+// ProfileEntry is the total for an instruction or a function.
+type ProfileEntry struct {
+	Name          string
+	Addr          BankAddr
+	Count, Cycles int64
+	Cum           int64 // cycles including callees, for functions
+}
+
+// enclosingLabel returns the closest label at or before addr that is not a
+// local label, as local labels are part of the function they are in.
+func (d *Debugger) enclosingLabel(addr BankAddr) (string, BankAddr, bool) {
+	name, at, ok := d.symbols.Before(addr)
+	if parent, _, local := strings.Cut(name, "."); ok && local {
+		if a, ok := d.symbols.Addr(parent); ok {
+			return parent, a, true
+		}
+	}
+	return name, at, ok
+}
+
+// function names the function containing addr: the label before it if there
+// is one, otherwise entry, the address the call stack says was called.
+func (d *Debugger) function(addr BankAddr, entry BankAddr) (string, BankAddr) {
+	if name, at, ok := d.enclosingLabel(addr); ok {
+		return name, at
+	}
+	return d.Describe(entry), entry
+}
+
+// profileFrames returns the addresses in a sample from the innermost out,
+// with the function each is in.
+func (d *Debugger) profileFrames(key profileKey) (addrs, funcs []BankAddr, names []string) {
+	stack := d.profile.stacks[key.stack]
+	addrs = append(addrs, key.pc)
+	for i := len(stack) - 1; i >= 0; i-- {
+		addrs = append(addrs, stack[i].From)
+	}
+	for i, addr := range addrs {
+		// the outermost frame is wherever the run started
+		entry := BankAddr{Addr: 0x0100}
+		if n := len(stack) - i; n > 0 {
+			entry = stack[n-1].To
+		}
+		name, at := d.function(addr, entry)
+		names = append(names, name)
+		funcs = append(funcs, at)
+	}
+	return addrs, funcs, names
+}
+
+// ProfileReport returns the instructions and functions that took the most
+// cycles, most first.
+func (d *Debugger) ProfileReport() (hot, funcs []ProfileEntry) {
+	if d.profile == nil {
+		return nil, nil
+	}
+	byAddr := map[BankAddr]*ProfileEntry{}
+	byFunc := map[BankAddr]*ProfileEntry{}
+	for key, c := range d.profile.samples {
+		e := byAddr[key.pc]
+		if e == nil {
+			e = &ProfileEntry{Addr: key.pc}
+			byAddr[key.pc] = e
+		}
+		e.Count += c.count
+		e.Cycles += c.cycles
+
+		// recursive functions only count once towards cumulative cycles
+		_, entries, names := d.profileFrames(key)
+		seen := map[BankAddr]bool{}
+		for i, at := range entries {
+			f := byFunc[at]
+			if f == nil {
+				f = &ProfileEntry{Name: names[i], Addr: at}
+				byFunc[at] = f
+			}
+			if i == 0 {
+				f.Count += c.count
+				f.Cycles += c.cycles
+			}
+			if !seen[at] {
+				f.Cum += c.cycles
+				seen[at] = true
+			}
+		}
+	}
+
+	// hot spots are named by the nearest label, local or not
+	for _, e := range byAddr {
+		name, at, ok := d.symbols.Before(e.Addr)
+		if !ok {
+			name, at = d.function(e.Addr, e.Addr)
+		}
+		e.Name = name
+		if at != e.Addr {
+			e.Name = fmt.Sprintf("%s+%d", name, int(e.Addr.Addr)-int(at.Addr))
+		}
+		hot = append(hot, *e)
+	}
+	for _, f := range byFunc {
+		funcs = append(funcs, *f)
+	}
+	byCycles := func(a, b ProfileEntry) int {
+		if a.Cycles != b.Cycles {
+			return int(b.Cycles - a.Cycles)
+		}
+		return int(a.Addr.Addr) - int(b.Addr.Addr)
+	}
+	slices.SortFunc(hot, byCycles)
+	slices.SortFunc(funcs, func(a, b ProfileEntry) int {
+		if a.Cum != b.Cum {
+			return int(b.Cum - a.Cum)
+		}
+		return byCycles(a, b)
+	})
+	return hot, funcs
+}
+
+// WriteProfileReport writes the top n hot spots and functions.
+func (d *Debugger) WriteProfileReport(w io.Writer, n int) {
+	hot, funcs := d.ProfileReport()
+	var total, count int64
+	for _, e := range hot {
+		total += e.Cycles
+		count += e.Count
+	}
+	pct := func(c int64) float64 { return float64(c) * 100 / float64(max(total, 1)) }
+
+	fmt.Fprintf(w, "%d instructions, %d cycles\n\n", count, total)
+	fmt.Fprintf(w, "%10s %6s %10s  %-7s %-24s %s\n", "cycles", "%", "count", "addr", "function", "instruction")
+	for _, e := range hot[:min(n, len(hot))] {
+		fmt.Fprintf(w, "%10d %5.1f%% %10d  %-7s %-24s %s\n", e.Cycles, pct(e.Cycles), e.Count, e.Addr, e.Name, d.Disassemble(e.Addr).Decoded)
+	}
+	fmt.Fprintf(w, "\n%10s %6s %10s %6s  %-7s %s\n", "flat", "%", "cum", "%", "addr", "function")
+	for _, f := range funcs[:min(n, len(funcs))] {
+		fmt.Fprintf(w, "%10d %5.1f%% %10d %5.1f%%  %-7s %s\n", f.Cycles, pct(f.Cycles), f.Cum, pct(f.Cum), f.Addr, f.Name)
+	}
+}
+
+// SaveProfile writes the profile in the gzipped protobuf format read by
+// go tool pprof. Addresses are bank<<16 | addr, and functions and lines come
+// from symbols and sources where loaded.
+func (d *Debugger) SaveProfile(file string) error {
+	if d.profile == nil {
+		return fmt.Errorf("not profiling")
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(d.pprof()); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// pprof encodes the profile as a perftools.profiles.Profile message.
+func (d *Debugger) pprof() []byte {
+	var p protoBuf
+	strs := map[string]int{"": 0}
+	table := []string{""}
+	str := func(s string) uint64 {
+		if i, ok := strs[s]; ok {
+			return uint64(i)
+		}
+		strs[s] = len(table)
+		table = append(table, s)
+		return uint64(len(table) - 1)
+	}
+	valueType := func(typ, unit string) []byte {
+		var v protoBuf
+		v.uint(1, str(typ))
+		v.uint(2, str(unit))
+		return v
+	}
+
+	p.bytes(1, valueType("instructions", "count"))
+	p.bytes(1, valueType("cycles", "count"))
+
+	type locKey struct {
+		addr BankAddr
+		fn   BankAddr
+	}
+	locs := map[locKey]uint64{}
+	fns := map[BankAddr]uint64{}
+	var locations, functions protoBuf
+	var total int64
+	for key, c := range d.profile.samples {
+		addrs, entries, names := d.profileFrames(key)
+		var ids []uint64
+		for i, addr := range addrs {
+			fn, ok := fns[entries[i]]
+			if !ok {
+				fn = uint64(len(fns) + 1)
+				fns[entries[i]] = fn
+				var f protoBuf
+				f.uint(1, fn)
+				f.uint(2, str(names[i]))
+				f.uint(3, str(names[i]))
+				if line, ok := d.SourceLine(entries[i]); ok {
+					f.uint(4, str(line.File))
+					f.uint(5, uint64(line.Line))
+				}
+				functions.bytes(5, f)
+			}
+
+			id, ok := locs[locKey{addr, entries[i]}]
+			if !ok {
+				id = uint64(len(locs) + 1)
+				locs[locKey{addr, entries[i]}] = id
+				var line protoBuf
+				line.uint(1, fn)
+				if l, ok := d.SourceLine(addr); ok {
+					line.uint(2, uint64(l.Line))
+				}
+				var l protoBuf
+				l.uint(1, id)
+				l.uint(2, 1)
+				l.uint(3, uint64(addr.Bank)<<16|uint64(addr.Addr))
+				l.bytes(4, line)
+				locations.bytes(4, l)
+			}
+			ids = append(ids, id)
+		}
+
+		var s protoBuf
+		s.packed(1, ids)
+		s.packed(2, []uint64{uint64(c.count), uint64(c.cycles)})
+		p.bytes(2, s)
+		total += c.cycles
+	}
+
+	var m protoBuf
+	m.uint(1, 1)
+	m.uint(3, 0x1000000)
+	m.uint(5, str(filepath.Base(d.romPath)))
+	m.uint(7, 1) // has_functions
+	m.uint(8, 1) // has_filenames
+	m.uint(9, 1) // has_line_numbers
+	p.bytes(3, m)
+	p = append(append(p, locations...), functions...)
+
+	// 2^20 machine cycles a second
+	duration := uint64(float64(total) * 1e9 / (1 << 20))
+	period := valueType("cycles", "count")
+	for _, s := range table {
+		p.bytes(6, []byte(s))
+	}
+	p.uint(10, duration)
+	p.bytes(11, period)
+	p.uint(12, 1)
+	return p
+}
+
+// protoBuf appends protobuf fields, just enough for pprof.
+type protoBuf []byte
+
+func (p *protoBuf) varint(v uint64) {
+	for v >= 0x80 {
+		*p = append(*p, byte(v)|0x80)
+		v >>= 7
+	}
+	*p = append(*p, byte(v))
+}
+
+func (p *protoBuf) uint(field int, v uint64) {
+	p.varint(uint64(field) << 3)
+	p.varint(v)
+}
+
+func (p *protoBuf) bytes(field int, b []byte) {
+	p.varint(uint64(field)<<3 | 2)
+	p.varint(uint64(len(b)))
+	*p = append(*p, b...)
+}
+
+func (p *protoBuf) packed(field int, vs []uint64) {
+	var b protoBuf
+	for _, v := range vs {
+		b.varint(v)
+	}
+	p.bytes(field, b)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func loadProfileROM(t *testing.T) *Debugger {
+	d := loadTestROM(t, map[uint16][]byte{
+		0x0100: {0xc3, 0x50, 0x01}, // jp Main
+		0x0150: {
+			0xcd, 0x00, 0x02, // call Delay
+			0x18, 0xfb, // jr Main
+		},
+		0x0200: {
+			0x06, 0x02, // ld b, 2
+			0x05,       // .loop: dec b
+			0x20, 0xfd, // jr nz, .loop
+			0xc9, // ret
+		},
+	})
+	d.symbols.Add(BankAddr{Addr: 0x0150}, "Main")
+	d.symbols.Add(BankAddr{Addr: 0x0200}, "Delay")
+	d.symbols.Add(BankAddr{Addr: 0x0202}, "Delay.loop")
+	return d
+}
+
+func TestProfileReport(t *testing.T) {
+	d := loadProfileROM(t)
+	d.StartProfile()
+	// jp, then 4 rounds of call, ld, dec, jr, dec, jr, ret, jr
+	d.RunFor(1 + 4*8)
+	d.StopProfile()
+
+	_, funcs := d.ProfileReport()
+	byName := map[string]ProfileEntry{}
+	for _, f := range funcs {
+		byName[f.Name] = f
+	}
+	// the local label counts towards Delay
+	if _, ok := byName["Delay.loop"]; ok {
+		t.Error("Delay.loop reported as a function")
+	}
+	delay, main := byName["Delay"], byName["Main"]
+	if delay.Count != 4*6 || main.Count != 4*2 {
+		t.Errorf("counted Main %d and Delay %d instructions, want 8 and 24", main.Count, delay.Count)
+	}
+	if main.Cum != main.Cycles+delay.Cycles {
+		t.Errorf("Main has %d cycles including Delay, want %d", main.Cum, main.Cycles+delay.Cycles)
+	}
+	if delay.Cum != delay.Cycles {
+		t.Errorf("Delay has %d cumulative cycles, want its own %d", delay.Cum, delay.Cycles)
+	}
+}
+
+// protoFields decodes the fields of a protobuf message, with varints as
+// numbers and length delimited fields as bytes.
+func protoFields(t *testing.T, b []byte) (nums map[int][]uint64, msgs map[int][][]byte) {
+	t.Helper()
+	nums, msgs = map[int][]uint64{}, map[int][][]byte{}
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("bad key")
+		}
+		b = b[n:]
+		switch field := int(key >> 3); key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatal("bad varint")
+			}
+			nums[field] = append(nums[field], v)
+			b = b[n:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || int(l) > len(b)-n {
+				t.Fatal("bad length")
+			}
+			msgs[field] = append(msgs[field], b[n:n+int(l)])
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return nums, msgs
+}
+
+func packed(t *testing.T, b []byte) []uint64 {
+	var vs []uint64
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("bad packed varint")
+		}
+		vs, b = append(vs, v), b[n:]
+	}
+	return vs
+}
+
+func TestSaveProfile(t *testing.T) {
+	d := loadProfileROM(t)
+	d.StartProfile()
+	d.RunFor(1 + 4*8)
+	cycles := int64(0)
+	for _, c := range d.profile.samples {
+		cycles += c.cycles
+	}
+
+	file := filepath.Join(t.TempDir(), "goboy.pb.gz")
+	if err := d.SaveProfile(file); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, msgs := protoFields(t, data)
+	var strs []string
+	for _, s := range msgs[6] {
+		strs = append(strs, string(s))
+	}
+	if len(strs) == 0 || strs[0] != "" {
+		t.Fatalf("string table %q doesn't start with an empty string", strs)
+	}
+	var types []string
+	for _, st := range msgs[1] {
+		nums, _ := protoFields(t, st)
+		types = append(types, strs[nums[1][0]]+"/"+strs[nums[2][0]])
+	}
+	if !slices.Equal(types, []string{"instructions/count", "cycles/count"}) {
+		t.Errorf("sample types %q", types)
+	}
+
+	var total [2]uint64
+	locs := map[uint64]bool{}
+	for _, l := range msgs[4] {
+		nums, _ := protoFields(t, l)
+		locs[nums[1][0]] = true
+	}
+	for _, s := range msgs[2] {
+		_, fields := protoFields(t, s)
+		for _, id := range packed(t, fields[1][0]) {
+			if !locs[id] {
+				t.Errorf("sample refers to missing location %d", id)
+			}
+		}
+		values := packed(t, fields[2][0])
+		total[0] += values[0]
+		total[1] += values[1]
+	}
+	if total[0] != 1+4*8 || int64(total[1]) != cycles {
+		t.Errorf("samples add up to %d instructions and %d cycles, want %d and %d", total[0], total[1], 1+4*8, cycles)
+	}
+
+	var names []string
+	for _, fn := range msgs[5] {
+		nums, _ := protoFields(t, fn)
+		names = append(names, strs[nums[2][0]])
+	}
+	slices.Sort(names)
+	// the unlabelled jp at the entry point is named by its address
+	if !slices.Equal(names, []string{"0100", "Delay", "Main"}) {
+		t.Errorf("functions %q, want 0100, Delay and Main", names)
+	}
+	if !bytes.Contains(data, []byte("test.gb")) {
+		t.Error("no mapping for the rom")
+	}
+}
+
+func TestProfileHalt(t *testing.T) {
+	d := loadTestROM(t, map[uint16][]byte{
+		0x0050: {0xd9},             // reti
+		0x0100: {0xc3, 0x50, 0x01}, // jp $0150
+		0x0150: {
+			0x3e, 0x04, // ld a, IEF_TIMER
+			0xe0, 0xff, // ldh [rIE], a
+			0x3e, 0x05, // ld a, TACF_START | TACF_262KHZ
+			0xe0, 0x07, // ldh [rTAC], a
+			0xfb,       // ei
+			0x76,       // .loop: halt
+			0x18, 0xfd, // jr .loop
+		},
+	})
+	d.StartProfile()
+	start := d.Z.CPU.cycles
+	steps, executed := 0, int64(0)
+	for ; steps < 20000; steps++ {
+		if err := d.step(); err != nil {
+			t.Fatal(err)
+		}
+		executed += int64(tern(d.executed, 1, 0))
+	}
+	if executed == int64(steps) {
+		t.Fatal("never idled in halt")
+	}
+
+	hot, _ := d.ProfileReport()
+	var count, cycles int64
+	byAddr := map[uint16]ProfileEntry{}
+	for _, e := range hot {
+		count += e.Count
+		cycles += e.Cycles
+		byAddr[e.Addr.Addr] = e
+	}
+	if count != executed || cycles != int64(d.Z.CPU.cycles-start) {
+		t.Errorf("profiled %d instructions and %d cycles, want %d and %d", count, cycles, executed, d.Z.CPU.cycles-start)
+	}
+	halt, jr, reti := byAddr[0x0159], byAddr[0x015a], byAddr[0x0050]
+	if halt.Count < 2 || jr.Count < halt.Count-1 || jr.Count > halt.Count {
+		t.Errorf("counted %d halts and %d jrs, want one jr after each halt", halt.Count, jr.Count)
+	}
+	if reti.Count != jr.Count {
+		t.Errorf("counted %d retis and %d jrs, want one reti before each jr", reti.Count, jr.Count)
+	}
+	// the time waiting goes to the halt, not the instruction after it
+	if halt.Cycles < 10*jr.Cycles {
+		t.Errorf("halt took %d cycles and jr %d, want the halt to dominate", halt.Cycles, jr.Cycles)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+// #include "../build/libcgoboy.h"
+import "C"
+
+const (
+	frameCycles = C.FRAME_CYCLES
+	undoCap     = 16 // ram writes per instruction
+
+	// roughly 10MB of deltas and 1MB of states
+	DefaultRewindInstructions = 100000
+	DefaultRewindFrames       = 60
+)
+
+// ring is a bounded stack that drops its oldest entries when full.
+type ring[T any] struct {
+	buf      []T
+	start, n int
+}
+
+func newRing[T any](size int) ring[T] {
+	return ring[T]{buf: make([]T, size)}
+}
+
+func (r *ring[T]) push(v T) {
+	if len(r.buf) == 0 {
+		return
+	}
+	if r.n == len(r.buf) {
+		r.start = (r.start + 1) % len(r.buf)
+		r.n--
+	}
+	r.buf[(r.start+r.n)%len(r.buf)] = v
+	r.n++
+}
+
+func (r *ring[T]) pop() (T, bool) {
+	var zero T
+	if r.n == 0 {
+		return zero, false
+	}
+	r.n--
+	i := (r.start + r.n) % len(r.buf)
+	v := r.buf[i]
+	r.buf[i] = zero
+	return v, true
+}
+
+func (r *ring[T]) peek() (T, bool) {
+	var zero T
+	if r.n == 0 {
+		return zero, false
+	}
+	return r.buf[(r.start+r.n-1)%len(r.buf)], true
+}
+
+func (r *ring[T]) clear() {
+	clear(r.buf)
+	r.start, r.n = 0, 0
+}
+
+// cpuRegs is the cpu state outside of memory.
+type cpuRegs struct {
+	b, c, d, e, h, l, a, f          C.uchar
+	sp, pc                          C.ushort
+	cycles, cyclesPrev              C.uint
+	halted, stopped, irqEnabled     C.uchar
+	cartReg1, cartReg2, cartReg3    C.uchar
+	xromBank, xramBank, xramEnabled C.uchar
+}
+
+func (d *Debugger) saveRegs() cpuRegs {
+	z := &d.Z.CPU
+	return cpuRegs{
+		z.b, z.c, z.d, z.e, z.h, z.l, z.a, z.f,
+		z.sp, z.pc,
+		z.cycles, z.cycles_prev,
+		z.halted, z.stopped, z.irq_enabled,
+		z.cart_reg1, z.cart_reg2, z.cart_reg3,
+		z.xrom_bank, z.xram_bank, z.xram_enabled,
+	}
+}
+
+func (d *Debugger) restoreRegs(r cpuRegs) {
+	z := &d.Z.CPU
+	z.b, z.c, z.d, z.e, z.h, z.l, z.a, z.f = r.b, r.c, r.d, r.e, r.h, r.l, r.a, r.f
+	z.sp, z.pc = r.sp, r.pc
+	z.cycles, z.cycles_prev = r.cycles, r.cyclesPrev
+	z.halted, z.stopped, z.irq_enabled = r.halted, r.stopped, r.irqEnabled
+	z.cart_reg1, z.cart_reg2, z.cart_reg3 = r.cartReg1, r.cartReg2, r.cartReg3
+	z.xrom_bank, z.xram_bank, z.xram_enabled = r.xromBank, r.xramBank, r.xramEnabled
+}
+
+type undoEntry struct {
+	addr uint16
+	old  byte
+}
+
+// delta undoes one instruction.
+type delta struct {
+	seq   uint64
+	regs  cpuRegs
+	undo  []undoEntry
+	calls []Frame // call stack before the instruction
+}
+
+// savedState is the full machine state at the start of a frame.
+type savedState struct {
+	seq   uint64
+	cpu   C.cpu
+	calls []Frame
+}
+
+type rewinder struct {
+	deltas ring[delta]
+	states ring[savedState]
+	seq    uint64 // instructions executed
+	frame  uint32
+
+	undoAddr *C.ushort
+	undoOld  *C.uchar
+	pending  delta
+}
+
+// EnableRewind keeps undo information for the last instructions executed and
+// full snapshots of the last frames. Passing 0 for both disables rewinding.
+func (d *Debugger) EnableRewind(instructions, frames int) {
+	r := &rewinder{
+		deltas: newRing[delta](instructions),
+		states: newRing[savedState](frames),
+		frame:  ^uint32(0),
+	}
+
+	// the undo log is allocated once and kept, like the watch flags
+	if d.rewind != nil {
+		r.undoAddr, r.undoOld = d.rewind.undoAddr, d.rewind.undoOld
+	} else {
+		r.undoAddr, _ = malloc[C.ushort](undoCap)
+		r.undoOld, _ = malloc[C.uchar](undoCap)
+	}
+	d.rewind = r
+
+	if instructions <= 0 && frames <= 0 {
+		d.Z.CPU.undo_addr, d.Z.CPU.undo_old, d.Z.CPU.undo_cap = nil, nil, 0
+		d.rewind = nil
+		return
+	}
+	d.Z.CPU.undo_addr, d.Z.CPU.undo_old, d.Z.CPU.undo_cap = r.undoAddr, r.undoOld, undoCap
+}
+
+// RewindHistory returns how many instructions can be stepped back and how
+// many frames can be rewound.
+func (d *Debugger) RewindHistory() (int, int) {
+	if d.rewind == nil {
+		return 0, 0
+	}
+	return d.rewind.deltas.n, d.rewind.states.n
+}
+
+// rewindBefore records the state before executing an instruction.
+func (d *Debugger) rewindBefore() {
+	r := d.rewind
+	if frame := uint32(d.Z.CPU.cycles) / frameCycles; frame != r.frame {
+		r.frame = frame
+		r.states.push(savedState{seq: r.seq, cpu: d.Z.CPU, calls: d.callStack})
+	}
+	r.pending = delta{seq: r.seq, regs: d.saveRegs(), calls: d.callStack}
+	d.Z.CPU.undo_len = 0
+}
+
+// rewindAfter completes the delta of the executed instruction.
+func (d *Debugger) rewindAfter() {
+	r := d.rewind
+	r.seq++
+
+	n := int(d.Z.CPU.undo_len)
+	if n > undoCap {
+		// can't undo this instruction, so nothing before it either
+		r.deltas.clear()
+		return
+	}
+	if n > 0 {
+		addrs, olds := asSlice(r.undoAddr, n), asSlice(r.undoOld, n)
+		r.pending.undo = make([]undoEntry, n)
+		for i := range r.pending.undo {
+			r.pending.undo[i] = undoEntry{uint16(addrs[i]), byte(olds[i])}
+		}
+	}
+	r.deltas.push(r.pending)
+}
+
+// StepBack undoes the last instruction.
+func (d *Debugger) StepBack() bool {
+	d.snapshot()
+	ok := d.stepBack()
+	d.InvalidateCPUState()
+	return ok
+}
+
+func (d *Debugger) stepBack() bool {
+	r := d.rewind
+	if r == nil {
+		return false
+	}
+	dl, ok := r.deltas.pop()
+	if !ok {
+		return false
+	}
+
+	for i := len(dl.undo) - 1; i >= 0; i-- {
+		d.Write(BankAddr{Addr: dl.undo[i].addr}, dl.undo[i].old, WriteRaw)
+	}
+	d.restoreRegs(dl.regs)
+	d.callStack = dl.calls
+	d.syncBanks()
+	d.lastWatch = nil
+	r.seq = dl.seq
+
+	// states from the future are no longer reachable
+	for st, ok := r.states.peek(); ok && st.seq > r.seq; st, ok = r.states.peek() {
+		r.states.pop()
+	}
+	r.frame = uint32(d.Z.CPU.cycles) / frameCycles
+	return true
+}
+
+// ReverseContinue steps back until a breakpoint or the start of history.
+func (d *Debugger) ReverseContinue() {
+	d.snapshot()
+	for d.stepBack() {
+		if d.breakpointCond(d.BankAddr(d.PC())) {
+			break
+		}
+	}
+	d.InvalidateCPUState()
+}
+
+// RewindFrames restores the state at the start of the nth previous frame, or
+// the oldest frame available. It returns the number of frames rewound.
+func (d *Debugger) RewindFrames(n int) int {
+	r := d.rewind
+	if r == nil {
+		return 0
+	}
+
+	var target *savedState
+	rewound := 0
+	for rewound < n {
+		st, ok := r.states.pop()
+		if !ok {
+			break
+		}
+		if st.seq < r.seq {
+			target = &st
+			rewound++
+		}
+	}
+	if target == nil {
+		return 0
+	}
+
+	d.snapshot()
+	z := &d.Z.CPU
+	watch, undoAddr, undoOld := z.watch, z.undo_addr, z.undo_old
+	*z = target.cpu
+	z.watch, z.undo_addr, z.undo_old, z.undo_cap = watch, undoAddr, undoOld, undoCap
+	z.watch_len, z.irq_vector = 0, 0
+	// cheats aren't part of the emulated state, so keep the current ones
+	d.syncCheats()
+	d.callStack = target.calls
+	d.syncBanks()
+	d.lastWatch = nil
+
+	// the state is still valid to rewind to, but anything after it isn't
+	r.seq = target.seq
+	r.states.push(*target)
+	r.frame = uint32(z.cycles) / frameCycles
+	for dl, ok := r.deltas.peek(); ok && dl.seq >= r.seq; dl, ok = r.deltas.peek() {
+		r.deltas.pop()
+	}
+
+	d.InvalidateCPUState()
+	return rewound
+}
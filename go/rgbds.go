@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// disasmMain implements the disasm subcommand, which writes the whole rom
+// out as RGBDS source.
+func disasmMain(args []string) error {
+	fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+	out := fs.String("o", "disasm", "output directory")
+	sym := fs.String("sym", "", "symbol file (default: <rom>.sym)")
+	cdl := fs.String("cdl", "", "code/data log to find code and data with")
+	patch := fs.String("patch", "", "IPS, UPS or BPS patch to apply to the rom first")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: goboy2 disasm [flags] rom.gb")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// patches next to the rom aren't applied, so the output reassembles to
+	// the rom as given
+	var patches []string
+	if *patch != "" {
+		patches = append(patches, *patch)
+	}
+	d := NewDebugger()
+	if err := d.Load(fs.Arg(0), patches...); err != nil {
+		return err
+	}
+	if *sym != "" {
+		if err := d.LoadSymbols(*sym); err != nil {
+			return err
+		}
+	}
+	if *cdl != "" {
+		if err := d.LoadCDL(*cdl); err != nil {
+			return err
+		}
+	}
+	rom := tern(*patch != "", d.rom, d.romFile)
+
+	r := DisassembleROM(d, rom)
+	name := strings.TrimSuffix(filepath.Base(fs.Arg(0)), filepath.Ext(fs.Arg(0)))
+	if err := r.Write(*out, name); err != nil {
+		return err
+	}
+	code := 0
+	for _, k := range r.kind {
+		code += tern(k != romData, 1, 0)
+	}
+	fmt.Printf("wrote %d banks to %s: %d code bytes, %d data bytes\n", r.banks(), *out, code, len(rom)-code)
+	return nil
+}
+
+type romByte uint8
+
+const (
+	romData romByte = iota
+	romCode
+	romOperand
+)
+
+// ROMDisassembly is a static disassembly of a whole rom. Code is found by
+// following branches from the entry point, the rst and interrupt vectors and
+// anything the code/data log saw executed. Everything else is data.
+type ROMDisassembly struct {
+	d        *Debugger
+	rom      []byte
+	kind     []romByte
+	ins      map[int]Instruction // by rom offset
+	labels   map[int]string
+	imported map[int]bool
+	ram      map[uint16]string // imported symbols outside of rom
+	queue    []int
+}
+
+func DisassembleROM(d *Debugger, rom []byte) *ROMDisassembly {
+	r := &ROMDisassembly{
+		d:        d,
+		rom:      rom,
+		kind:     make([]romByte, len(rom)),
+		ins:      make(map[int]Instruction),
+		labels:   make(map[int]string),
+		imported: make(map[int]bool),
+		ram:      make(map[uint16]string),
+	}
+
+	for addr := uint16(0); addr <= 0x60; addr += 8 {
+		if name, ok := vectorName(addr); ok {
+			r.entry(int(addr), name)
+		}
+	}
+	r.entry(0x100, "Entry")
+	for off := range rom {
+		if r.cdl(off)&CDLExec != 0 {
+			r.queue = append(r.queue, off)
+		}
+	}
+	r.trace()
+
+	imported := make(map[string]bool)
+	for addr, name := range d.symbols.names {
+		// skip names that were later moved to another address
+		if !rgbdsIdent.MatchString(name) || d.symbols.addrs[name] != addr {
+			continue
+		}
+		imported[name] = true
+		if off, ok := r.romOffset(int(addr.Bank), addr.Addr); ok && addr.Addr < 0x8000 {
+			r.labels[off] = name
+			r.imported[off] = true
+		} else if addr.Addr >= 0x8000 && addr.Bank == 0 && !strings.Contains(name, ".") {
+			r.ram[addr.Addr] = name
+		}
+	}
+
+	// labels can only be defined once and between instructions
+	for off, name := range r.labels {
+		if r.kind[off] == romOperand || !r.imported[off] && imported[name] {
+			delete(r.labels, off)
+		}
+	}
+	return r
+}
+
+var rgbdsIdent = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_#@.]*$`)
+
+func (r *ROMDisassembly) banks() int {
+	return (len(r.rom) + 0x3fff) / 0x4000
+}
+
+// romOffset returns the rom offset of addr as seen from code in bank. Code
+// in bank 0 can only see a known bank at 0x4000-0x7fff if there is only one.
+func (r *ROMDisassembly) romOffset(bank int, addr uint16) (int, bool) {
+	off := int(addr)
+	switch {
+	case addr >= 0x8000:
+		return 0, false
+	case addr >= 0x4000 && bank == 0 && r.banks() != 2:
+		return 0, false
+	case addr >= 0x4000:
+		off = max(bank, 1)*0x4000 + int(addr) - 0x4000
+	}
+	return off, off < len(r.rom)
+}
+
+func romBankAddr(off int) BankAddr {
+	if off < 0x4000 {
+		return BankAddr{Addr: uint16(off)}
+	}
+	return BankAddr{Bank: uint8(off / 0x4000), Addr: uint16(0x4000 + off%0x4000)}
+}
+
+func (r *ROMDisassembly) cdl(off int) CDLFlags {
+	return r.d.CDL(romBankAddr(off))
+}
+
+func (r *ROMDisassembly) entry(off int, label string) {
+	if off < len(r.rom) {
+		r.labels[off] = label
+		r.queue = append(r.queue, off)
+	}
+}
+
+// decode returns the instruction at off if it can be code: it is valid, it
+// does not overlap anything already decoded or logged as data, and it does
+// not run off the end of its bank.
+func (r *ROMDisassembly) decode(off int) (Instruction, bool) {
+	if off >= len(r.rom) || r.kind[off] != romData {
+		return Instruction{}, false
+	}
+	end := min(len(r.rom), (off/0x4000+1)*0x4000)
+	ins := Decode(romBankAddr(off).Addr, r.rom[off:min(end, off+3)])
+	if !ins.Valid() || off+ins.Length > end {
+		return ins, false
+	}
+	for i := 0; i < ins.Length; i++ {
+		if f := r.cdl(off + i); r.kind[off+i] != romData || f&(CDLRead|CDLExec|CDLOperand) == CDLRead {
+			return ins, false
+		}
+	}
+	return ins, true
+}
+
+func (r *ROMDisassembly) trace() {
+	for len(r.queue) > 0 {
+		off := r.queue[len(r.queue)-1]
+		r.queue = r.queue[:len(r.queue)-1]
+
+		for {
+			ins, ok := r.decode(off)
+			if !ok {
+				break
+			}
+			r.ins[off] = ins
+			r.kind[off] = romCode
+			for i := 1; i < ins.Length; i++ {
+				r.kind[off+i] = romOperand
+			}
+
+			if target, ok := r.romOffset(off/0x4000, ins.Target); ins.HasTarget && ok {
+				if _, ok := r.labels[target]; !ok {
+					b := romBankAddr(target)
+					r.labels[target] = fmt.Sprintf("%s_%03X_%04X", tern(ins.IsCall(), "Call", "Jump"), b.Bank, b.Addr)
+				}
+				r.queue = append(r.queue, target)
+			}
+			if ins.Cond == "" && (ins.IsJump() || ins.IsReturn()) {
+				break
+			}
+			off += ins.Length
+		}
+	}
+}
+
+// format returns ins at off in RGBDS syntax, or false if it has to be
+// written as data to reassemble to the same bytes.
+func (r *ROMDisassembly) format(off int, ins Instruction) (string, bool) {
+	switch {
+	case ins.Mnemonic == "stop":
+		// rgbasm always adds a byte after stop
+		return "", false
+	case ins.Mnemonic == "ld" && slices.ContainsFunc(ins.Operands, func(op Operand) bool { return op.Kind == OperandAddr && op.Value >= 0xff00 }):
+		// rgbasm may optimise these into ldh
+		return "", false
+	case ins.Mnemonic == "ldhl":
+		return fmt.Sprintf("ld hl, sp%+d", ins.Operands[1].Value), true
+	}
+
+	mnemonic := ins.Mnemonic
+	var args []string
+	if ins.Cond != "" {
+		args = append(args, ins.Cond)
+	}
+	if len(ins.Operands) == 1 && (mnemonic == "add" || mnemonic == "adc" || mnemonic == "sbc") {
+		args = append(args, "a")
+	}
+	for _, op := range ins.Operands {
+		switch op.Kind {
+		case OperandReg:
+			args = append(args, op.Reg)
+		case OperandIndirect:
+			reg := op.Reg
+			switch mnemonic {
+			case "ldi":
+				reg = "hl+"
+			case "ldd":
+				reg = "hl-"
+			}
+			args = append(args, "["+reg+"]")
+		case OperandImm8, OperandVector:
+			args = append(args, fmt.Sprintf("$%02x", op.Value))
+		case OperandImm16, OperandRel:
+			args = append(args, r.name(off, uint16(op.Value), ins.HasTarget))
+		case OperandAddr:
+			args = append(args, "["+r.name(off, uint16(op.Value), false)+"]")
+		case OperandOffset:
+			args = append(args, fmt.Sprintf("%d", op.Value))
+		case OperandBit:
+			args = append(args, fmt.Sprintf("%d", op.Value))
+		}
+	}
+	mnemonic = normalizeMnemonic(mnemonic)
+	if ins.Mnemonic == "ldh" {
+		mnemonic = "ldh"
+	}
+	if len(args) == 0 {
+		return mnemonic, true
+	}
+	return mnemonic + " " + strings.Join(args, ", "), true
+}
+
+// name returns the label for addr as used by the code at off. Only branch
+// targets use generated labels, values that look like addresses could just
+// be numbers.
+func (r *ROMDisassembly) name(off int, addr uint16, target bool) string {
+	if to, ok := r.romOffset(off/0x4000, addr); ok {
+		if name, ok := r.labels[to]; ok && (target || r.imported[to]) {
+			return name
+		}
+	} else if name, ok := r.ram[addr]; ok {
+		return name
+	}
+	return fmt.Sprintf("$%04x", addr)
+}
+
+// Write writes name.asm, which includes one file per bank, to dir.
+func (r *ROMDisassembly) Write(dir, name string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, name+".asm"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "; Disassembled by goboy2. Rebuild from this directory with\n")
+	fmt.Fprintf(w, ";   rgbasm -o %[1]s.o %[1]s.asm && rgblink -o %[1]s.gb %[1]s.o\n\n", name)
+
+	var addrs []uint16
+	for addr := range r.ram {
+		addrs = append(addrs, addr)
+	}
+	slices.Sort(addrs)
+	for _, addr := range addrs {
+		fmt.Fprintf(w, "DEF %s EQU $%04x\n", r.ram[addr], addr)
+	}
+	if len(addrs) > 0 {
+		fmt.Fprintln(w)
+	}
+
+	for bank := 0; bank < r.banks(); bank++ {
+		file := fmt.Sprintf("bank_%03X.asm", bank)
+		fmt.Fprintf(w, "INCLUDE \"%s\"\n", file)
+		if err := r.writeBank(filepath.Join(dir, file), bank); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (r *ROMDisassembly) writeBank(file string, bank int) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	if bank == 0 {
+		fmt.Fprintf(w, "SECTION \"ROM Bank $000\", ROM0[$0000]\n")
+	} else {
+		fmt.Fprintf(w, "SECTION \"ROM Bank $%03X\", ROMX[$4000], BANK[$%X]\n", bank, bank)
+	}
+
+	start, end := bank*0x4000, min(len(r.rom), (bank+1)*0x4000)
+	for off := start; off < end; {
+		if label, ok := r.labels[off]; ok {
+			fmt.Fprintf(w, "\n%s:\n", label)
+		}
+
+		if r.kind[off] == romCode {
+			ins := r.ins[off]
+			if s, ok := r.format(off, ins); ok {
+				fmt.Fprintf(w, "\t%s\n", s)
+				off += ins.Length
+				continue
+			}
+			// written as data, but keep it on its own line
+			fmt.Fprintf(w, "\tdb %s ; %s\n", r.dataBytes(off, off+ins.Length), ins)
+			off += ins.Length
+			continue
+		}
+
+		n := 1
+		for n < 8 && off+n < end && r.kind[off+n] == romData {
+			if _, ok := r.labels[off+n]; ok {
+				break
+			}
+			n++
+		}
+		fmt.Fprintf(w, "\tdb %s\n", r.dataBytes(off, off+n))
+		off += n
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (r *ROMDisassembly) dataBytes(start, end int) string {
+	bytes := make([]string, end-start)
+	for i := range bytes {
+		bytes[i] = fmt.Sprintf("$%02x", r.rom[start+i])
+	}
+	return strings.Join(bytes, ", ")
+}
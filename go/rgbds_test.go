@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func loadDisasmROM(t *testing.T) *Debugger {
+	d := loadTestROM(t, map[uint16][]byte{
+		0x0100: {0x00, 0xc3, 0x50, 0x01}, // nop; jp Main
+		0x0150: {
+			0xf8, 0x05, // ld hl, sp+5
+			0xf8, 0xfe, // ld hl, sp-2
+			0xe2,       // ldh [c], a
+			0xf2,       // ldh a, [c]
+			0xe0, 0x80, // ldh [$ff80], a
+			0xea, 0x44, 0xff, // ld [$ff44], a, which rgbasm may turn into ldh
+			0xfa, 0x00, 0xc1, // ld a, [wLives]
+			0xcd, 0x00, 0x02, // call Sub
+			0xcd, 0x00, 0x03, // call $0300
+			0x21, 0x61, 0x01, // ld hl, $0161, a number not a label
+			0x10, 0x00, // stop
+			0x20, 0xfc, // jr nz, $0167
+			0xc3, 0x00, 0x40, // jp $4000
+			0x18, 0xfe, // jr @
+		},
+		0x0200: {0x3e, 0x01, 0xc9}, // Sub: ld a, 1; ret
+		0x0210: {0x01, 0x02, 0x03}, // Data
+		0x0300: {0xc9},             // ret
+		0x4000: {
+			0xcb, 0x37, // swap a
+			0x21, 0x01, 0x02, // ld hl, $0201, inside Sub
+			0x18, 0xfe, // jr @
+		},
+	})
+	d.symbols.Add(BankAddr{Addr: 0x0200}, "Sub")
+	d.symbols.Add(BankAddr{Addr: 0x0201}, "Sub.operand")
+	d.symbols.Add(BankAddr{Addr: 0x0210}, "Data")
+	d.symbols.Add(BankAddr{Addr: 0xc100}, "wLives")
+	return d
+}
+
+// TestDisassembleROM assembles each line written for the rom and checks it
+// gives back the rom's bytes, with every label where it was.
+func TestDisassembleROM(t *testing.T) {
+	d := loadDisasmROM(t)
+	rom := d.romFile
+	r := DisassembleROM(d, rom)
+	dir := t.TempDir()
+	if err := r.Write(dir, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	main, err := os.ReadFile(filepath.Join(dir, "test.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var banks [][]string
+	for bank := 0; bank < r.banks(); bank++ {
+		file := fmt.Sprintf("bank_%03X.asm", bank)
+		if !bytes.Contains(main, []byte(`INCLUDE "`+file+`"`)) {
+			t.Errorf("test.asm doesn't include %s", file)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		banks = append(banks, strings.Split(strings.TrimSuffix(string(b), "\n"), "\n"))
+	}
+
+	// only labels that are written can be used, at the addresses they were
+	// found at, which is checked below
+	labels := map[string]int{}
+	for off, name := range r.labels {
+		labels[name] = off
+	}
+	symbols := map[string]uint16{}
+	for _, lines := range banks {
+		for _, line := range lines {
+			if name, ok := strings.CutSuffix(line, ":"); ok {
+				symbols[name] = romBankAddr(labels[name]).Addr
+			}
+		}
+	}
+	for _, line := range strings.Split(string(main), "\n") {
+		var name string
+		var addr uint16
+		if n, _ := fmt.Sscanf(line, "DEF %s EQU $%x", &name, &addr); n == 2 {
+			symbols[name] = addr
+		}
+	}
+	if symbols["wLives"] != 0xc100 {
+		t.Errorf("wLives not defined:\n%s", main)
+	}
+	symbol := func(name string) (uint16, bool) {
+		addr, ok := symbols[name]
+		return addr, ok
+	}
+
+	written := map[string]bool{}
+	defined := map[string]int{}
+	for bank, lines := range banks {
+		off, end := bank*0x4000, min(len(rom), (bank+1)*0x4000)
+		for _, line := range lines {
+			switch {
+			case line == "" || strings.HasPrefix(line, "SECTION "):
+				continue
+			case strings.HasSuffix(line, ":"):
+				name := strings.TrimSuffix(line, ":")
+				defined[name]++
+				if at, ok := labels[name]; !ok || at != off {
+					t.Errorf("%s defined at %x, want %x", name, off, at)
+				}
+				continue
+			}
+
+			src := strings.TrimPrefix(line, "\t")
+			written[src] = true
+			var got []byte
+			if data, ok := strings.CutPrefix(src, "db "); ok {
+				data, _, _ = strings.Cut(data, ";")
+				for _, b := range strings.Split(data, ",") {
+					n, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(b), "$"), 16, 8)
+					if err != nil {
+						t.Fatalf("%x: %q: %v", off, line, err)
+					}
+					got = append(got, byte(n))
+				}
+			} else if got, err = Assemble(romBankAddr(off).Addr, src, symbol); err != nil {
+				t.Fatalf("%x: %q: %v", off, src, err)
+			}
+			if want := rom[off:min(end, off+len(got))]; !bytes.Equal(got, want) {
+				t.Fatalf("%x: %q assembles to % x, want % x", off, src, got, want)
+			}
+			off += len(got)
+		}
+		if off != end {
+			t.Errorf("bank %d ends at %x, want %x", bank, off, end)
+		}
+	}
+
+	for name, n := range defined {
+		if n != 1 {
+			t.Errorf("%s defined %d times", name, n)
+		}
+	}
+	for _, name := range []string{"Entry", "Sub", "Data", "Call_000_0300", "Jump_000_0167", "Jump_001_4000"} {
+		if defined[name] != 1 {
+			t.Errorf("no label %s", name)
+		}
+	}
+	// labels can't go between the bytes of an instruction
+	if defined["Sub.operand"] != 0 {
+		t.Error("Sub.operand defined inside an instruction")
+	}
+	for _, src := range []string{
+		"ld hl, sp+5",
+		"ld hl, sp-2",
+		"ldh [c], a",
+		"ldh a, [c]",
+		"ldh [$ff80], a",
+		"db $ea, $44, $ff ; ld ($ff44), a",
+		"ld a, [wLives]",
+		"call Sub",
+		"call Call_000_0300",
+		"ld hl, $0161",
+		"db $10 ; stop", // rgbasm would add a nop
+		"jr nz, Jump_000_0167",
+		"jp Jump_001_4000",
+		"swap a",
+		"ld hl, $0201",
+	} {
+		if !written[src] {
+			t.Errorf("no line %q", src)
+		}
+	}
+
+	// the whole thing with the real assembler, where there is one
+	t.Run("rgbasm", func(t *testing.T) {
+		for _, tool := range []string{"rgbasm", "rgblink"} {
+			if _, err := exec.LookPath(tool); err != nil {
+				t.Skip(tool + " not installed")
+			}
+		}
+		for _, args := range [][]string{
+			{"rgbasm", "-o", "test.o", "test.asm"},
+			{"rgblink", "-o", "test.gb", "test.o"},
+		} {
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Dir = dir
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("%s: %v\n%s", args[0], err, out)
+			}
+		}
+		built, err := os.ReadFile(filepath.Join(dir, "test.gb"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(built, rom) {
+			t.Error("rgbasm and rgblink built a different rom")
+		}
+	})
+}
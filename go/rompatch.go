@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// bpsMain implements the bps subcommand, which assembles inline patches
+// into a BPS patch for the rom. Each line of the source is an address and an
+// instruction, as given to the asm console command.
+func bpsMain(args []string) error {
+	fs := flag.NewFlagSet("bps", flag.ExitOnError)
+	out := fs.String("o", "", "output file (default: <patches>.bps)")
+	sym := fs.String("sym", "", "symbol file (default: <rom>.sym)")
+	var patches stringList
+	fs.Var(&patches, "patch", "apply this IPS, UPS or BPS patch first, may be repeated")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: goboy2 bps [flags] rom.gb patches.asm")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(fs.Arg(1), filepath.Ext(fs.Arg(1))) + ".bps"
+	}
+
+	d := NewDebugger()
+	if err := d.Load(fs.Arg(0), patches...); err != nil {
+		return err
+	}
+	if *sym != "" {
+		if err := d.LoadSymbols(*sym); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line, _, _ := strings.Cut(s.Text(), ";")
+		fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), "asm "))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		addr, err := d.ParseAddr(fields[0])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", fs.Arg(1), n, err)
+		}
+		_, warning, err := d.Patch(addr, strings.Join(fields[1:], " "))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", fs.Arg(1), n, err)
+		}
+		if warning != "" {
+			log.Printf("%s:%d: warning: %s", fs.Arg(1), n, warning)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	if err := d.SaveBPS(*out); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d patches to %s\n", len(d.Patches()), *out)
+	return nil
+}
+
+// romPatchExts are the patches picked up alongside a rom, in the order they
+// are applied.
+var romPatchExts = []string{".ips", ".ups", ".bps"}
+
+// findROMPatches returns the patches named after file, e.g. game.ips for
+// game.gb.
+func findROMPatches(file string) []string {
+	var found []string
+	base := strings.TrimSuffix(file, filepath.Ext(file))
+	for _, ext := range romPatchExts {
+		if _, err := os.Stat(base + ext); err == nil {
+			found = append(found, base+ext)
+		}
+	}
+	return found
+}
+
+// ApplyROMPatch applies the IPS, UPS or BPS patch in file to rom, detected by
+// its header.
+func ApplyROMPatch(rom []byte, file string) ([]byte, error) {
+	patch, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	switch {
+	case bytes.HasPrefix(patch, []byte("PATCH")):
+		out, err = ApplyIPS(rom, patch)
+	case bytes.HasPrefix(patch, []byte("UPS1")):
+		out, err = ApplyUPS(rom, patch)
+	case bytes.HasPrefix(patch, []byte("BPS1")):
+		out, err = ApplyBPS(rom, patch)
+	default:
+		return nil, fmt.Errorf("%s: not an IPS, UPS or BPS patch", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return out, nil
+}
+
+var errPatchTruncated = fmt.Errorf("patch is truncated")
+
+// ApplyIPS applies an IPS patch. Records past the end of rom extend it.
+func ApplyIPS(rom, patch []byte) ([]byte, error) {
+	out := bytes.Clone(rom)
+	p := patch[5:]
+	for {
+		if len(p) < 3 {
+			return nil, errPatchTruncated
+		}
+		if string(p[:3]) == "EOF" {
+			break
+		}
+		if len(p) < 5 {
+			return nil, errPatchTruncated
+		}
+		off := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		size := int(binary.BigEndian.Uint16(p[3:]))
+		p = p[5:]
+
+		var data []byte
+		if size == 0 {
+			// run length encoded
+			if len(p) < 3 {
+				return nil, errPatchTruncated
+			}
+			data = bytes.Repeat(p[2:3], int(binary.BigEndian.Uint16(p)))
+			p = p[3:]
+		} else {
+			if len(p) < size {
+				return nil, errPatchTruncated
+			}
+			data, p = p[:size], p[size:]
+		}
+		if end := off + len(data); end > len(out) {
+			out = append(out, make([]byte, end-len(out))...)
+		}
+		copy(out[off:], data)
+	}
+
+	// the lunar ips extension truncates the rom
+	if p = p[3:]; len(p) >= 3 {
+		if size := int(p[0])<<16 | int(p[1])<<8 | int(p[2]); size < len(out) {
+			out = out[:size]
+		}
+	}
+	return out, nil
+}
+
+// patchReader reads the variable length numbers used by UPS and BPS.
+type patchReader struct {
+	p   []byte
+	pos int
+	end int // start of the checksums
+	err error
+}
+
+func (r *patchReader) byte() byte {
+	if r.pos >= r.end {
+		r.err = errPatchTruncated
+		return 0
+	}
+	r.pos++
+	return r.p[r.pos-1]
+}
+
+func (r *patchReader) number() int {
+	n, shift := 0, 1
+	for r.err == nil {
+		b := r.byte()
+		n += int(b&0x7f) * shift
+		if b&0x80 != 0 {
+			break
+		}
+		shift <<= 7
+		n += shift
+	}
+	return n
+}
+
+// checkCRCs verifies the trailing checksums of a UPS or BPS patch.
+func checkCRCs(source, target, patch []byte) error {
+	crcs := patch[len(patch)-12:]
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != binary.LittleEndian.Uint32(crcs[8:]) {
+		return fmt.Errorf("patch is corrupt")
+	}
+	if crc32.ChecksumIEEE(source) != binary.LittleEndian.Uint32(crcs) {
+		return fmt.Errorf("patch is for a different rom")
+	}
+	if crc32.ChecksumIEEE(target) != binary.LittleEndian.Uint32(crcs[4:]) {
+		return fmt.Errorf("patched rom does not match the patch checksum")
+	}
+	return nil
+}
+
+// ApplyUPS applies a UPS patch, which xors the rom with the patch.
+func ApplyUPS(rom, patch []byte) ([]byte, error) {
+	if len(patch) < 4+12 {
+		return nil, errPatchTruncated
+	}
+	r := &patchReader{p: patch, pos: 4, end: len(patch) - 12}
+	sourceSize, targetSize := r.number(), r.number()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if sourceSize != len(rom) {
+		return nil, fmt.Errorf("patch is for a %d byte rom, not %d bytes", sourceSize, len(rom))
+	}
+
+	out := make([]byte, targetSize)
+	copy(out, rom)
+	for i := 0; r.pos < r.end && r.err == nil; i++ {
+		i += r.number()
+		for ; r.err == nil; i++ {
+			b := r.byte()
+			if b == 0 {
+				break
+			}
+			if i < len(out) {
+				out[i] ^= b
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return out, checkCRCs(rom, out, patch)
+}
+
+// BPS actions
+const (
+	bpsSourceRead = iota
+	bpsTargetRead
+	bpsSourceCopy
+	bpsTargetCopy
+)
+
+// ApplyBPS applies a BPS patch.
+func ApplyBPS(rom, patch []byte) ([]byte, error) {
+	if len(patch) < 4+12 {
+		return nil, errPatchTruncated
+	}
+	r := &patchReader{p: patch, pos: 4, end: len(patch) - 12}
+	sourceSize, targetSize := r.number(), r.number()
+	r.pos += r.number() // metadata
+	if r.err != nil || r.pos > r.end {
+		return nil, errPatchTruncated
+	}
+	if sourceSize != len(rom) {
+		return nil, fmt.Errorf("patch is for a %d byte rom, not %d bytes", sourceSize, len(rom))
+	}
+
+	out := make([]byte, 0, targetSize)
+	sourceRel, targetRel := 0, 0
+	signed := func() int {
+		n := r.number()
+		return tern(n&1 != 0, -(n >> 1), n>>1)
+	}
+	for r.pos < r.end && r.err == nil {
+		n := r.number()
+		action, length := n&3, n>>2+1
+		if len(out)+length > targetSize {
+			return nil, fmt.Errorf("patch writes past the end of the rom")
+		}
+		switch action {
+		case bpsSourceRead:
+			if len(out)+length > len(rom) {
+				return nil, fmt.Errorf("patch reads past the end of the rom")
+			}
+			out = append(out, rom[len(out):len(out)+length]...)
+		case bpsTargetRead:
+			for i := 0; i < length; i++ {
+				out = append(out, r.byte())
+			}
+		case bpsSourceCopy:
+			sourceRel += signed()
+			if sourceRel < 0 || sourceRel+length > len(rom) {
+				return nil, fmt.Errorf("patch reads past the end of the rom")
+			}
+			out = append(out, rom[sourceRel:sourceRel+length]...)
+			sourceRel += length
+		case bpsTargetCopy:
+			targetRel += signed()
+			if targetRel < 0 || targetRel >= len(out) {
+				return nil, fmt.Errorf("patch copies from outside the rom")
+			}
+			// byte by byte, as the copy may overlap what it writes
+			for i := 0; i < length; i++ {
+				out = append(out, out[targetRel])
+				targetRel++
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(out) != targetSize {
+		return nil, fmt.Errorf("patched rom is %d bytes, expected %d", len(out), targetSize)
+	}
+	return out, checkCRCs(rom, out, patch)
+}
+
+func appendPatchNumber(b []byte, n int) []byte {
+	for {
+		x := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(b, x|0x80)
+		}
+		b = append(b, x)
+		n--
+	}
+}
+
+// CreateBPS creates a BPS patch from source to target. It only reads from
+// the source in place or from the patch, which suits patches that change
+// bytes but do not move them.
+func CreateBPS(source, target []byte) []byte {
+	p := []byte("BPS1")
+	p = appendPatchNumber(p, len(source))
+	p = appendPatchNumber(p, len(target))
+	p = appendPatchNumber(p, 0)
+
+	same := func(i int) bool { return i < len(source) && source[i] == target[i] }
+	for i := 0; i < len(target); {
+		start, action := i, tern(same(i), bpsSourceRead, bpsTargetRead)
+		for i < len(target) && same(i) == (action == bpsSourceRead) {
+			i++
+		}
+		p = appendPatchNumber(p, (i-start-1)<<2|action)
+		if action == bpsTargetRead {
+			p = append(p, target[start:i]...)
+		}
+	}
+
+	p = binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(source))
+	p = binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(target))
+	return binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(p))
+}
+
+// PatchedROM returns the rom as loaded with the inline patches to rom
+// applied. Other writes to rom, e.g. with poke, are left out.
+func (d *Debugger) PatchedROM() []byte {
+	rom := bytes.Clone(d.rom)
+	for _, p := range d.patches {
+		for i, b := range p.New {
+			addr := d.Offset(p.Addr, i)
+			if off := romFileOffset(addr); addr.Addr < 0x8000 && off < len(rom) {
+				rom[off] = b
+			}
+		}
+	}
+	return rom
+}
+
+// SaveBPS writes the inline patches as a BPS patch for the rom file as it
+// was before any patches given to Load.
+func (d *Debugger) SaveBPS(file string) error {
+	if d.romFile == nil {
+		return fmt.Errorf("no rom loaded")
+	}
+	return os.WriteFile(file, CreateBPS(d.romFile, d.PatchedROM()), 0644)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// withCRCs appends the source, target and patch checksums of UPS and BPS.
+func withCRCs(p, source, target []byte) []byte {
+	p = binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(source))
+	p = binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(target))
+	return binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(p))
+}
+
+func TestCRC32(t *testing.T) {
+	// the check value of CRC-32/ISO-HDLC, as used by UPS and BPS
+	if got := crc32.ChecksumIEEE([]byte("123456789")); got != 0xcbf43926 {
+		t.Errorf("got %08x, want cbf43926", got)
+	}
+}
+
+func TestPatchNumber(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x80}},
+		{1, []byte{0x81}},
+		{127, []byte{0xff}},
+		{128, []byte{0x00, 0x80}},
+		{129, []byte{0x01, 0x80}},
+		{16511, []byte{0x7f, 0xff}},
+		{16512, []byte{0x00, 0x00, 0x80}},
+	}
+	for _, tt := range tests {
+		got := appendPatchNumber(nil, tt.n)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("appendPatchNumber(%d) = % x, want % x", tt.n, got, tt.want)
+		}
+		r := &patchReader{p: got, end: len(got)}
+		if n := r.number(); n != tt.n || r.err != nil {
+			t.Errorf("number(% x) = %d, %v, want %d", got, n, r.err, tt.n)
+		}
+	}
+}
+
+func TestApplyIPS(t *testing.T) {
+	rom := []byte("ABCDEFGH")
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"record", "PATCH\x00\x00\x02\x00\x02zzEOF", "ABzzEFGH"},
+		{"rle", "PATCH\x00\x00\x01\x00\x00\x00\x03rEOF", "ArrrEFGH"},
+		{"extend", "PATCH\x00\x00\x0a\x00\x01zEOF", "ABCDEFGH\x00\x00z"},
+		{"truncate", "PATCH\x00\x00\x00\x00\x01aEOF\x00\x00\x05", "aBCDE"},
+	}
+	for _, tt := range tests {
+		got, err := ApplyIPS(rom, []byte(tt.patch))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := ApplyIPS(rom, []byte("PATCH\x00\x00\x02\x00\x04zz")); err == nil {
+		t.Error("truncated patch applied")
+	}
+}
+
+func TestApplyUPS(t *testing.T) {
+	source, target := []byte("ABCDEFGH"), []byte("ABCdEFGHIJ")
+	p := []byte("UPS1")
+	p = appendPatchNumber(p, len(source))
+	p = appendPatchNumber(p, len(target))
+	p = appendPatchNumber(p, 3) // to D
+	p = append(p, 'D'^'d', 0)
+	p = appendPatchNumber(p, 3) // past the terminator to the end of the source
+	p = append(p, 'I', 'J', 0)
+	p = withCRCs(p, source, target)
+
+	got, err := ApplyUPS(source, p)
+	if err != nil || !bytes.Equal(got, target) {
+		t.Errorf("got %q, %v, want %q", got, err, target)
+	}
+	checkPatchCRCs(t, ApplyUPS, source, p)
+}
+
+func TestApplyBPS(t *testing.T) {
+	source, target := []byte("ABCDEFGH"), []byte("ABCxyxyxyDEFGH")
+	action := func(p []byte, action, length int) []byte {
+		return appendPatchNumber(p, (length-1)<<2|action)
+	}
+	p := []byte("BPS1")
+	p = appendPatchNumber(p, len(source))
+	p = appendPatchNumber(p, len(target))
+	p = appendPatchNumber(p, 0)
+	p = action(p, bpsSourceRead, 3)
+	p = append(action(p, bpsTargetRead, 2), 'x', 'y')
+	p = appendPatchNumber(action(p, bpsTargetCopy, 4), 3<<1) // overlapping xyxy
+	p = appendPatchNumber(action(p, bpsSourceCopy, 2), 3<<1) // DE
+	p = appendPatchNumber(action(p, bpsSourceCopy, 3), 0)    // FGH
+	p = withCRCs(p, source, target)
+
+	got, err := ApplyBPS(source, p)
+	if err != nil || !bytes.Equal(got, target) {
+		t.Errorf("got %q, %v, want %q", got, err, target)
+	}
+	checkPatchCRCs(t, ApplyBPS, source, p)
+}
+
+// checkPatchCRCs checks that a patch is refused when it is corrupt or for a
+// different rom.
+func checkPatchCRCs(t *testing.T, apply func(rom, patch []byte) ([]byte, error), source, patch []byte) {
+	t.Helper()
+	corrupt := bytes.Clone(patch)
+	corrupt[len(corrupt)-13] ^= 0xff
+	if _, err := apply(source, corrupt); err == nil {
+		t.Error("corrupt patch applied")
+	}
+	other := bytes.Clone(source)
+	other[0] ^= 0xff
+	if _, err := apply(other, patch); err == nil {
+		t.Error("patch applied to a different rom")
+	}
+}
+
+func TestCreateBPS(t *testing.T) {
+	source := make([]byte, 0x8000)
+	for i := range source {
+		source[i] = byte(i * 7)
+	}
+	tests := []struct {
+		name   string
+		target func() []byte
+	}{
+		{"same", func() []byte { return bytes.Clone(source) }},
+		{"changed", func() []byte {
+			b := bytes.Clone(source)
+			copy(b[0x150:], "\x3e\x01\xc9")
+			b[0x7fff] = 0
+			return b
+		}},
+		{"grown", func() []byte { return append(bytes.Clone(source), make([]byte, 0x8000)...) }},
+		{"shrunk", func() []byte { return bytes.Clone(source[:0x4000]) }},
+	}
+	for _, tt := range tests {
+		target := tt.target()
+		p := CreateBPS(source, target)
+		got, err := ApplyBPS(source, p)
+		if err != nil || !bytes.Equal(got, target) {
+			t.Errorf("%s: round trip failed: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import "fmt"
+
+// SearchOp filters RAM search candidates by comparing each with its value at
+// the previous search.
+type SearchOp int
+
+const (
+	SearchEqual SearchOp = iota
+	SearchChanged
+	SearchIncreased
+	SearchDecreased
+	SearchValue // equal to a given value
+)
+
+var searchOps = map[string]SearchOp{
+	"equal":     SearchEqual,
+	"changed":   SearchChanged,
+	"increased": SearchIncreased,
+	"decreased": SearchDecreased,
+	"value":     SearchValue,
+}
+
+// SearchCandidate is an address that has matched every search so far.
+type SearchCandidate struct {
+	Addr        uint16
+	Prev, Value int
+}
+
+// RAMSearch narrows down where in ram a value is kept, e.g. the number of
+// lives, by snapshotting ram and repeatedly keeping only the addresses that
+// changed as expected. Values are 1 or 2 bytes, little endian, and may be
+// BCD, in which case addresses holding anything else are dropped.
+type RAMSearch struct {
+	d          *Debugger
+	Size       int
+	BCD        bool
+	candidates []SearchCandidate
+}
+
+// searchRegions are searched: cart ram, wram and hram.
+var searchRegions = [][2]int{{0xa000, 0xc000}, {0xc000, 0xe000}, {0xff80, 0xffff}}
+
+func NewRAMSearch(d *Debugger, size int, bcd bool) *RAMSearch {
+	s := &RAMSearch{d: d, Size: size, BCD: bcd}
+	for _, r := range searchRegions {
+		if r[0] == 0xa000 && d.Z.CPU.xram == nil {
+			continue
+		}
+		for addr := r[0]; addr+size <= r[1]; addr++ {
+			if v, ok := s.read(uint16(addr)); ok {
+				s.candidates = append(s.candidates, SearchCandidate{Addr: uint16(addr), Prev: v, Value: v})
+			}
+		}
+	}
+	return s
+}
+
+// read returns the value at addr, or false if it is not valid BCD.
+func (s *RAMSearch) read(addr uint16) (int, bool) {
+	v := 0
+	for i := s.Size - 1; i >= 0; i-- {
+		b := int(s.d.Read(addr + uint16(i)))
+		if !s.BCD {
+			v = v<<8 | b
+		} else if b>>4 > 9 || b&0xf > 9 {
+			return 0, false
+		} else {
+			v = v*100 + b>>4*10 + b&0xf
+		}
+	}
+	return v, true
+}
+
+// Filter keeps the candidates whose value now compared with their value at
+// the last search matches op. value is only used by SearchValue.
+func (s *RAMSearch) Filter(op SearchOp, value int) {
+	kept := s.candidates[:0]
+	for _, c := range s.candidates {
+		v, ok := s.read(c.Addr)
+		if !ok {
+			continue
+		}
+		match := false
+		switch op {
+		case SearchEqual:
+			match = v == c.Value
+		case SearchChanged:
+			match = v != c.Value
+		case SearchIncreased:
+			match = v > c.Value
+		case SearchDecreased:
+			match = v < c.Value
+		case SearchValue:
+			match = v == value
+		}
+		if match {
+			kept = append(kept, SearchCandidate{Addr: c.Addr, Prev: c.Value, Value: v})
+		}
+	}
+	s.candidates = kept
+}
+
+// Candidates returns the addresses left, with their values at the last two
+// searches.
+func (s *RAMSearch) Candidates() []SearchCandidate {
+	return s.candidates
+}
+
+func (s *RAMSearch) String() string {
+	return fmt.Sprintf("%d-bit%s, %d candidates", s.Size*8, tern(s.BCD, " bcd", ""), len(s.candidates))
+}
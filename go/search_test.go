@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestRAMSearchBCD(t *testing.T) {
+	d := loadTestROM(t, nil)
+	poke := func(addr uint16, b ...byte) {
+		for i, v := range b {
+			d.Write(BankAddr{Addr: addr + uint16(i)}, v, WriteRaw)
+		}
+	}
+	find := func(s *RAMSearch, addr uint16) (SearchCandidate, bool) {
+		for _, c := range s.Candidates() {
+			if c.Addr == addr {
+				return c, true
+			}
+		}
+		return SearchCandidate{}, false
+	}
+
+	poke(0xc200, 0x42, 0x4a)
+	s := NewRAMSearch(d, 1, true)
+	if c, ok := find(s, 0xc200); !ok || c.Value != 42 {
+		t.Errorf("c200: got %+v, %v, want 42", c, ok)
+	}
+	if _, ok := find(s, 0xc201); ok {
+		t.Error("c201 holds 4a, which isn't BCD")
+	}
+	if _, ok := find(s, 0xa000); ok {
+		t.Error("searched cart ram without any")
+	}
+
+	// 16-bit values are little endian
+	poke(0xc300, 0x99, 0x01)
+	s = NewRAMSearch(d, 2, true)
+	if c, ok := find(s, 0xc300); !ok || c.Value != 199 {
+		t.Fatalf("c300: got %+v, %v, want 199", c, ok)
+	}
+	poke(0xc300, 0x00, 0x02)
+	s.Filter(SearchIncreased, 0)
+	if c, ok := find(s, 0xc300); !ok || c.Prev != 199 || c.Value != 200 {
+		t.Errorf("increased: got %+v, %v, want 199 to 200", c, ok)
+	}
+	// c301-c302 went from 1 to 2, and nothing else changed
+	if n := len(s.Candidates()); n != 2 {
+		t.Errorf("increased: %d candidates, want 2", n)
+	}
+	s.Filter(SearchValue, 200)
+	if _, ok := find(s, 0xc300); !ok {
+		t.Error("value 200: c300 dropped")
+	}
+	poke(0xc301, 0x0a)
+	s.Filter(SearchChanged, 0)
+	if _, ok := find(s, 0xc300); ok {
+		t.Error("changed to 0a00, which isn't BCD, but kept")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SourceLine is a 1-based line in an assembly source file.
+type SourceLine struct {
+	File string
+	Line int
+}
+
+// SourceMap maps lines of RGBDS sources to rom addresses. .sym files only
+// have labels, so the lines after each label are matched instruction by
+// instruction against the rom. Anything that isn't an instruction (data,
+// macros, SECTION) loses track of the address until the next label.
+type SourceMap struct {
+	lines map[string]map[int]BankAddr
+	addrs map[BankAddr]SourceLine
+}
+
+func NewSourceMap() *SourceMap {
+	return &SourceMap{
+		lines: make(map[string]map[int]BankAddr),
+		addrs: make(map[BankAddr]SourceLine),
+	}
+}
+
+var sourceExts = []string{".asm", ".s", ".inc", ".z80", ".sm83"}
+
+// LoadSources maps all assembly sources under dir.
+func (d *Debugger) LoadSources(dir string) error {
+	return filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, ext := range sourceExts {
+			if !e.IsDir() && strings.EqualFold(filepath.Ext(path), ext) {
+				return d.LoadSource(path)
+			}
+		}
+		return nil
+	})
+}
+
+// LoadSource maps file, replacing any earlier map of it. Symbols must be
+// loaded first.
+func (d *Debugger) LoadSource(file string) error {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	m := d.sources
+	for _, addr := range m.lines[file] {
+		if m.addrs[addr].File == file {
+			delete(m.addrs, addr)
+		}
+	}
+	lines := make(map[int]BankAddr)
+	m.lines[file] = lines
+
+	var addr BankAddr
+	known := false
+	scope := ""
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		label, rest := splitLabel(s.Text())
+		if label != "" {
+			if strings.HasPrefix(label, ".") {
+				label = scope + label
+			} else {
+				scope, _, _ = strings.Cut(label, ".")
+			}
+			addr, known = d.symbols.Addr(label)
+			if known {
+				lines[n] = addr
+			}
+		}
+
+		fields := strings.Fields(rest)
+		if len(fields) == 0 || !known {
+			continue
+		}
+		mnemonic := strings.ToLower(fields[0])
+		ins := Decode(addr.Addr, []byte{d.ReadBank(addr), d.ReadBank(d.Offset(addr, 1)), d.ReadBank(d.Offset(addr, 2))})
+		if !sourceMnemonics[mnemonic] || normalizeMnemonic(mnemonic) != normalizeMnemonic(ins.Mnemonic) {
+			known = false
+			continue
+		}
+		lines[n] = addr
+		if _, ok := m.addrs[addr]; !ok {
+			m.addrs[addr] = SourceLine{file, n}
+		}
+		addr = d.Offset(addr, ins.Length)
+	}
+	return s.Err()
+}
+
+// SourceAddr returns the address of the code at line of file.
+func (d *Debugger) SourceAddr(file string, line int) (BankAddr, bool) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return BankAddr{}, false
+	}
+	addr, ok := d.sources.lines[file][line]
+	return addr, ok
+}
+
+// SourceLine returns the line of the instruction at addr.
+func (d *Debugger) SourceLine(addr BankAddr) (SourceLine, bool) {
+	line, ok := d.sources.addrs[addr]
+	return line, ok
+}
+
+// HasSource reports whether file has been mapped.
+func (d *Debugger) HasSource(file string) bool {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	_, ok := d.sources.lines[file]
+	return ok
+}
+
+// splitLabel splits a source line into its label, if any, and the rest
+// without comments. Labels end in colons, except for local labels which
+// may be written without.
+func splitLabel(line string) (string, string) {
+	line, _, _ = strings.Cut(line, ";")
+	if line == "" || line[0] == ' ' || line[0] == '\t' {
+		return "", line
+	}
+	end := strings.IndexAny(line, " \t:")
+	if end < 0 {
+		end = len(line)
+	}
+	label, rest := line[:end], line[end:]
+	if strings.HasPrefix(rest, ":") {
+		return label, strings.TrimLeft(rest, ":")
+	}
+	if strings.HasPrefix(label, ".") {
+		return label, rest
+	}
+	// a directive such as SECTION or an EQU
+	return "", " " + line
+}
+
+// sourceMnemonics are the instructions accepted by rgbasm.
+var sourceMnemonics = map[string]bool{}
+
+func init() {
+	for _, o := range append(opcodes[:], cbOpcodes[:]...) {
+		if o.mnemonic != "xx" {
+			sourceMnemonics[o.mnemonic] = true
+		}
+	}
+}
+
+// normalizeMnemonic folds the aliases for loads that rgbasm accepts, like
+// ldi and ld [hl+], or ldh and ld [$ff00+n].
+func normalizeMnemonic(m string) string {
+	switch m {
+	case "ldi", "ldd", "ldh", "ldhl":
+		return "ld"
+	}
+	return m
+}
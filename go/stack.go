@@ -0,0 +1,62 @@
+package main
+
+import "fmt"
+
+type StackWord struct {
+	Addr, Value uint16
+	Note        string // return address or pushed register, if known
+}
+
+// Stack returns n 16-bit words at and above SP.
+func (d *Debugger) Stack(n int) []StackWord {
+	sp := uint16(d.Z.CPU.sp)
+	words := make([]StackWord, 0, n)
+	for i := 0; i < n; i++ {
+		addr := sp + uint16(i*2)
+		w := StackWord{Addr: addr, Value: uint16(d.Read(addr)) | uint16(d.Read(addr+1))<<8}
+		w.Note = d.stackNote(w)
+		words = append(words, w)
+	}
+	return words
+}
+
+func (d *Debugger) stackNote(w StackWord) string {
+	for _, f := range d.callStack {
+		if f.SP == w.Addr && f.Return == w.Value {
+			return fmt.Sprintf("%s %s", tern(f.Interrupt, "irq", "ret from"), d.Describe(f.To))
+		}
+	}
+	if reg, ok := d.pushes[w.Addr]; ok {
+		return "push " + reg
+	}
+
+	// not seen being pushed, but looks like it was
+	ret := d.BankAddr(w.Value)
+	for _, n := range []int{3, 1} {
+		from := d.Offset(ret, -n)
+		var bytes [3]byte
+		for i := range bytes {
+			bytes[i] = d.ReadBank(d.Offset(from, i))
+		}
+		if ins := Decode(from.Addr, bytes[:]); ins.IsCall() && ins.Length == n {
+			return "ret? from " + d.Describe(d.TargetAddr(from, ins.Target))
+		}
+	}
+	return ""
+}
+
+// trackPushes remembers which register was pushed to each stack slot. Slots
+// are forgotten once anything else is stored below the old stack pointer sp.
+func (d *Debugger) trackPushes(ins Instruction, sp uint16) {
+	newSP := uint16(d.Z.CPU.sp)
+	if newSP < sp {
+		for addr := range d.pushes {
+			if addr >= newSP && addr < sp {
+				delete(d.pushes, addr)
+			}
+		}
+		if ins.Mnemonic == "push" && newSP == sp-2 && d.Z.CPU.irq_vector == 0 {
+			d.pushes[newSP] = ins.Operands[0].Reg
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Symbols is a bank-aware symbol table loaded from RGBDS/no$gmb .sym files.
+type Symbols struct {
+	names map[BankAddr]string
+	addrs map[string]BankAddr
+}
+
+func NewSymbols() *Symbols {
+	return &Symbols{
+		names: make(map[BankAddr]string),
+		addrs: make(map[string]BankAddr),
+	}
+}
+
+// LoadSymbols reads lines of the form "05:4123 PlayerUpdate". Comments start
+// with ';'.
+func (d *Debugger) LoadSymbols(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line, _, _ := strings.Cut(s.Text(), ";")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		bank, addr, ok := strings.Cut(fields[0], ":")
+		if !ok || len(fields) != 2 {
+			return fmt.Errorf("%s:%d: bad symbol %q", file, n, line)
+		}
+		b, err := parseHex(bank, 8)
+		if err != nil {
+			return fmt.Errorf("%s:%d: bad bank %q", file, n, bank)
+		}
+		a, err := parseHex(addr, 16)
+		if err != nil {
+			return fmt.Errorf("%s:%d: bad address %q", file, n, addr)
+		}
+		d.symbols.Add(BankAddr{Bank: tern(isBanked(uint16(a)), uint8(b), 0), Addr: uint16(a)}, fields[1])
+	}
+	d.InvalidateDasmCache()
+	return s.Err()
+}
+
+// Add names addr. If addr already has a name, local labels (Func.loop) do not
+// replace it.
+func (s *Symbols) Add(addr BankAddr, name string) {
+	s.addrs[name] = addr
+	if old, ok := s.names[addr]; !ok || (strings.Contains(old, ".") && !strings.Contains(name, ".")) {
+		s.names[addr] = name
+	}
+}
+
+func (s *Symbols) Name(addr BankAddr) (string, bool) {
+	name, ok := s.names[addr]
+	return name, ok
+}
+
+func (s *Symbols) Addr(name string) (BankAddr, bool) {
+	addr, ok := s.addrs[name]
+	return addr, ok
+}
+
+func (s *Symbols) Names() []string {
+	var names []string
+	for name := range s.addrs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Before returns the closest symbol at or before addr in the same bank and
+// 16k page, e.g. the function addr is in.
+func (s *Symbols) Before(addr BankAddr) (string, BankAddr, bool) {
+	var name string
+	var best BankAddr
+	found := false
+	for a, n := range s.names {
+		if a.Bank != addr.Bank || a.Addr>>14 != addr.Addr>>14 || a.Addr > addr.Addr {
+			continue
+		}
+		if !found || a.Addr > best.Addr {
+			name, best, found = n, a, true
+		}
+	}
+	return name, best, found
+}
+
+// TargetAddr qualifies an operand address of the instruction at from. Rom
+// addresses in the switchable bank are assumed to be in the same bank as the
+// instruction if it is itself in the switchable bank.
+func (d *Debugger) TargetAddr(from BankAddr, target uint16) BankAddr {
+	if target >= 0x4000 && target < 0x8000 && from.Addr >= 0x4000 && from.Addr < 0x8000 {
+		return BankAddr{Bank: from.Bank, Addr: target}
+	}
+	return d.BankAddr(target)
+}
+
+// symbolize formats ins with operands that refer to symbols, IO registers
+// or vectors replaced by their names.
+func (d *Debugger) symbolize(from BankAddr, ins Instruction) string {
+	return ins.Format(func(op Operand) (string, bool) {
+		switch op.Kind {
+		case OperandImm16, OperandRel, OperandAddr:
+			addr := d.TargetAddr(from, uint16(op.Value))
+			if name, ok := d.symbols.Name(addr); ok {
+				return name, true
+			}
+			if op.Kind == OperandAddr {
+				return ioName(addr.Addr)
+			}
+			if ins.HasTarget {
+				return vectorName(addr.Addr)
+			}
+		case OperandVector:
+			if name, ok := d.symbols.Name(BankAddr{Addr: uint16(op.Value)}); ok {
+				return name, true
+			}
+			return vectorName(uint16(op.Value))
+		}
+		return "", false
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const AnyBank = -1
+
+// TraceFilter limits tracing to instructions in Start-End (inclusive) and,
+// unless Bank is AnyBank, in that bank.
+type TraceFilter struct {
+	Start, End uint16
+	Bank       int
+}
+
+var TraceAll = TraceFilter{End: 0xffff, Bank: AnyBank}
+
+func (f TraceFilter) Match(pc BankAddr) bool {
+	return pc.Addr >= f.Start && pc.Addr <= f.End && (f.Bank == AnyBank || int(pc.Bank) == f.Bank)
+}
+
+// ParseTraceFilter parses an optional addr-addr range and bank.
+func ParseTraceFilter(rng, bank string) (TraceFilter, error) {
+	filter := TraceAll
+	if rng != "" {
+		from, to, ok := strings.Cut(rng, "-")
+		if !ok {
+			return filter, fmt.Errorf("bad range %q", rng)
+		}
+		start, err := parseHex(from, 16)
+		if err != nil {
+			return filter, err
+		}
+		end, err := parseHex(to, 16)
+		if err != nil {
+			return filter, err
+		}
+		filter.Start, filter.End = uint16(start), uint16(end)
+	}
+	if bank != "" {
+		b, err := parseHex(bank, 8)
+		if err != nil {
+			return filter, err
+		}
+		filter.Bank = int(b)
+	}
+	return filter, nil
+}
+
+type tracer struct {
+	f      *os.File
+	w      *bufio.Writer
+	filter TraceFilter
+	cycles bool
+	line   []byte // the state before the current step, written once it runs an instruction
+}
+
+// StartTrace logs the cpu state before every instruction to file in the
+// gameboy-doctor format. With cycles, the cycle count is appended as CY so
+// that tracediff can reload to any line.
+func (d *Debugger) StartTrace(file string, filter TraceFilter, cycles bool) error {
+	if err := d.StopTrace(); err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	d.trace = &tracer{f: f, w: bufio.NewWriterSize(f, 1<<16), filter: filter, cycles: cycles}
+	return nil
+}
+
+func (d *Debugger) StopTrace() error {
+	if d.trace == nil {
+		return nil
+	}
+	t := d.trace
+	d.trace = nil
+	if err := t.w.Flush(); err != nil {
+		t.f.Close()
+		return err
+	}
+	return t.f.Close()
+}
+
+func (d *Debugger) Tracing() bool {
+	return d.trace != nil
+}
+
+// traceStep formats the cpu state before the instruction at pc. The line is
+// only written by traceExecuted, as a step can also idle in halt or dispatch
+// an interrupt, and gameboy-doctor logs one line per instruction.
+func (d *Debugger) traceStep(pc BankAddr) {
+	t := d.trace
+	t.line = t.line[:0]
+	if t.filter.Match(pc) {
+		t.line = append(d.appendTraceLine(t.line, pc, t.cycles), '\n')
+	}
+}
+
+// appendTraceLine appends the cpu state in the gameboy-doctor format.
+func (d *Debugger) appendTraceLine(b []byte, pc BankAddr, cycles bool) []byte {
+	z := &d.Z.CPU
+	b = fmt.Appendf(b, "A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X",
+		z.a, z.f, z.b, z.c, z.d, z.e, z.h, z.l, z.sp, z.pc,
+		d.Read(pc.Addr), d.Read(pc.Addr+1), d.Read(pc.Addr+2), d.Read(pc.Addr+3))
+	if cycles {
+		b = fmt.Appendf(b, " CY:%d", z.cycles)
+	}
+	return b
+}
+
+func (d *Debugger) traceExecuted() {
+	d.trace.w.Write(d.trace.line)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	d := loadTestROM(t, map[uint16][]byte{
+		0x0050: {0x76}, // halt
+		0x0100: {
+			0x3e, 0x04, // ld a, 4
+			0xe0, 0xff, // ldh [IE], a
+			0xfb,       // ei
+			0xe0, 0x0f, // ldh [IF], a
+		},
+	})
+	// the state after the boot rom, which gameboy-doctor logs start from
+	for r, v := range map[string]int{"af": 0x01b0, "bc": 0x0013, "de": 0x00d8, "hl": 0x014d, "sp": 0xfffe} {
+		if err := d.SetRegister(r, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(t.TempDir(), "trace.log")
+	if err := d.StartTrace(file, TraceAll, false); err != nil {
+		t.Fatal(err)
+	}
+	// steps rather than instructions, as the last one halts for good
+	for i := 0; i < 10; i++ {
+		d.step()
+	}
+	if err := d.StopTrace(); err != nil {
+		t.Fatal(err)
+	}
+
+	// one line per instruction: the timer interrupt being dispatched and
+	// idling in halt aren't logged
+	want := []string{
+		"A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:3E,04,E0,FF",
+		"A:04 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0102 PCMEM:E0,FF,FB,E0",
+		"A:04 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0104 PCMEM:FB,E0,0F,00",
+		"A:04 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0105 PCMEM:E0,0F,00,00",
+		"A:04 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFC PC:0050 PCMEM:76,00,00,00",
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got trace\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseTraceFilter(t *testing.T) {
+	tests := []struct {
+		rng, bank string
+		want      TraceFilter
+		err       bool
+	}{
+		{"", "", TraceAll, false},
+		{"0150-01ff", "", TraceFilter{Start: 0x0150, End: 0x01ff, Bank: AnyBank}, false},
+		{"4000-7fff", "2", TraceFilter{Start: 0x4000, End: 0x7fff, Bank: 2}, false},
+		{"0150", "", TraceAll, true},
+		{"", "x", TraceAll, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTraceFilter(tt.rng, tt.bank)
+		if (err != nil) != tt.err || !tt.err && got != tt.want {
+			t.Errorf("ParseTraceFilter(%q, %q) = %+v, %v", tt.rng, tt.bank, got, err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// traceFields are compared in this order. Fields missing from either line
+// (e.g. PCMEM in older logs) and unknown fields such as CY are ignored.
+var traceFields = []string{"A", "F", "B", "C", "D", "E", "H", "L", "SP", "PC", "PCMEM"}
+
+// TraceDiff is the first difference between two trace logs.
+type TraceDiff struct {
+	Line    int      // 1-based
+	A, B    string   // the differing lines, empty if that log ended
+	Context []string // preceding lines of the first log
+	Fields  []string // what diverged, e.g. "F (Z, C)"
+}
+
+// Cycle returns the cycle count at the difference if either log was traced
+// with cycles.
+func (t *TraceDiff) Cycle() (uint32, bool) {
+	for _, line := range []string{t.A, t.B} {
+		if cy, ok := parseTraceLine(line)["CY"]; ok {
+			n, err := strconv.ParseUint(cy, 10, 32)
+			return uint32(n), err == nil
+		}
+	}
+	return 0, false
+}
+
+// parseTraceLine splits a gameboy-doctor style line into its fields. Keys are
+// upper cased and values are compared case insensitively.
+func parseTraceLine(line string) map[string]string {
+	fields := make(map[string]string)
+	for _, f := range strings.Fields(line) {
+		if k, v, ok := strings.Cut(f, ":"); ok {
+			fields[strings.ToUpper(k)] = strings.ToUpper(v)
+		}
+	}
+	return fields
+}
+
+func diffTraceLines(a, b string) []string {
+	fa, fb := parseTraceLine(a), parseTraceLine(b)
+	var diffs []string
+	compared := false
+	for _, k := range traceFields {
+		va, oka := fa[k]
+		vb, okb := fb[k]
+		compared = compared || oka && okb
+		if !oka || !okb || va == vb {
+			continue
+		}
+		if k == "F" {
+			diffs = append(diffs, "F ("+diffFlags(va, vb)+")")
+		} else {
+			diffs = append(diffs, k)
+		}
+	}
+
+	// not trace lines at all, e.g. a truncated last line
+	if !compared && a != b {
+		diffs = append(diffs, "line")
+	}
+	return diffs
+}
+
+func diffFlags(a, b string) string {
+	x, errA := strconv.ParseUint(a, 16, 8)
+	y, errB := strconv.ParseUint(b, 16, 8)
+	if errA != nil || errB != nil {
+		return "?"
+	}
+	var flags []string
+	for i, name := range []string{"Z", "N", "H", "C"} {
+		if bit := uint64(0x80 >> i); x&bit != y&bit {
+			flags = append(flags, name)
+		}
+	}
+	if len(flags) == 0 {
+		return "low bits"
+	}
+	return strings.Join(flags, ", ")
+}
+
+// DiffTraces streams both logs and returns their first difference, or nil
+// if they are the same.
+func DiffTraces(a, b io.Reader, context int) (*TraceDiff, error) {
+	sa, sb := bufio.NewScanner(a), bufio.NewScanner(b)
+	sa.Buffer(nil, 1<<20)
+	sb.Buffer(nil, 1<<20)
+
+	var prev []string
+	for line := 1; ; line++ {
+		okA, okB := sa.Scan(), sb.Scan()
+		if !okA || !okB {
+			if err := sa.Err(); err != nil {
+				return nil, err
+			}
+			if err := sb.Err(); err != nil {
+				return nil, err
+			}
+			if okA == okB {
+				return nil, nil
+			}
+			return &TraceDiff{Line: line, A: tern(okA, sa.Text(), ""), B: tern(okB, sb.Text(), ""), Context: prev}, nil
+		}
+
+		if diffs := diffTraceLines(sa.Text(), sb.Text()); len(diffs) > 0 {
+			return &TraceDiff{Line: line, A: sa.Text(), B: sb.Text(), Context: prev, Fields: diffs}, nil
+		}
+
+		if context > 0 {
+			if len(prev) == context {
+				prev = prev[1:]
+			}
+			prev = append(prev, sa.Text())
+		}
+	}
+}
+
+// traceDiffMain implements the tracediff subcommand.
+func traceDiffMain(args []string) error {
+	fs := flag.NewFlagSet("tracediff", flag.ExitOnError)
+	context := fs.Int("context", 5, "lines of context to show")
+	rom := fs.String("rom", "", "reload this rom to the difference and debug it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: goboy2 tracediff [flags] a.log b.log")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	fa, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer fa.Close()
+	fb, err := os.Open(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer fb.Close()
+
+	diff, err := DiffTraces(fa, fb, *context)
+	if err != nil {
+		return err
+	}
+	if diff == nil {
+		fmt.Println("traces are identical")
+		return nil
+	}
+
+	fmt.Printf("first difference at line %d", diff.Line)
+	if cy, ok := diff.Cycle(); ok {
+		fmt.Printf(" (cycle %d)", cy)
+	}
+	fmt.Println()
+	for i, l := range diff.Context {
+		fmt.Printf("  %8d %s\n", diff.Line-len(diff.Context)+i, l)
+	}
+	fmt.Printf("- %8d %s\n", diff.Line, tern(diff.A == "", "<end of "+fs.Arg(0)+">", diff.A))
+	fmt.Printf("+ %8d %s\n", diff.Line, tern(diff.B == "", "<end of "+fs.Arg(1)+">", diff.B))
+	if len(diff.Fields) > 0 {
+		fmt.Println("diverged:", strings.Join(diff.Fields, ", "))
+	}
+
+	if *rom == "" {
+		return nil
+	}
+
+	// stop just before the differing instruction
+	d := NewDebugger()
+	if err := d.Load(*rom); err != nil {
+		return err
+	}
+	d.EnableRewind(DefaultRewindInstructions, DefaultRewindFrames)
+	stop := drainSerial(d, io.Discard)
+	if cy, ok := diff.Cycle(); ok {
+		err = d.RunToCycle(cy)
+	} else if diff.Line > 1 {
+		// lines needn't be every instruction, e.g. with -trace-range, so
+		// follow them rather than counting
+		if _, err = fa.Seek(0, io.SeekStart); err == nil {
+			err = d.ReplayTrace(fa, diff.Line-1)
+		}
+	}
+	stop()
+	if err != nil {
+		return fmt.Errorf("running to line %d: %w", diff.Line, err)
+	}
+	return runUI(d)
+}
+
+// replayMaxCycles bounds how long ReplayTrace looks for the next line, about
+// a minute of emulated time.
+const replayMaxCycles = 60 << 20
+
+// ReplayTrace runs until the first n lines of trace have executed. Each line
+// is matched against the cpu state before each instruction, so traces that
+// only log some addresses or banks are followed too.
+func (d *Debugger) ReplayTrace(trace io.Reader, n int) error {
+	d.snapshot()
+	defer d.InvalidateDasmCache()
+
+	s := bufio.NewScanner(trace)
+	var state []byte
+	for line := 1; line <= n && s.Scan(); line++ {
+		want := s.Text()
+		pc, err := strconv.ParseUint(parseTraceLine(want)["PC"], 16, 16)
+		if err != nil {
+			return fmt.Errorf("line %d: no PC", line)
+		}
+		for start := uint32(d.Z.CPU.cycles); ; {
+			if uint32(d.Z.CPU.cycles)-start > replayMaxCycles {
+				return fmt.Errorf("line %d not reached: %s", line, want)
+			}
+			matched := false
+			if d.PC() == uint16(pc) {
+				state = d.appendTraceLine(state[:0], d.BankAddr(d.PC()), false)
+				matched = len(diffTraceLines(want, string(state))) == 0
+			}
+			if err := d.step(); err != nil {
+				return err
+			}
+			if matched && d.executed {
+				break
+			}
+		}
+	}
+	return s.Err()
+}
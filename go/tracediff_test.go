@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiffTraces(t *testing.T) {
+	const (
+		l1 = "A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02"
+		l2 = "A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0101 PCMEM:C3,13,02,CE"
+		l3 = "A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0213 PCMEM:C3,17,02,F5"
+	)
+	lines := func(l ...string) string { return strings.Join(l, "\n") + "\n" }
+	tests := []struct {
+		name string
+		a, b string
+		want *TraceDiff
+	}{
+		{"same", lines(l1, l2, l3), lines(l1, l2, l3), nil},
+		{"case and extra fields", lines(l1, l2), lines(strings.ToLower(l1), l2+" CY:4"), nil},
+		{"no PCMEM", lines(l1, l2), lines(l1, l2[:strings.Index(l2, " PCMEM")]), nil},
+		{"flags", lines(l1, l2, l3), lines(l1, strings.Replace(l2, "F:B0", "F:20", 1), l3),
+			&TraceDiff{Line: 2, A: l2, B: strings.Replace(l2, "F:B0", "F:20", 1), Context: []string{l1}, Fields: []string{"F (Z, C)"}}},
+		{"registers", lines(l1), lines(strings.Replace(strings.Replace(l1, "A:01", "A:02", 1), "SP:FFFE", "SP:DFFF", 1)),
+			&TraceDiff{Line: 1, A: l1, B: strings.Replace(strings.Replace(l1, "A:01", "A:02", 1), "SP:FFFE", "SP:DFFF", 1), Fields: []string{"A", "SP"}}},
+		{"ended", lines(l1, l2, l3), lines(l1, l2), &TraceDiff{Line: 3, A: l3, Context: []string{l1, l2}}},
+		{"not trace lines", lines(l1, "x"), lines(l1, "y"), &TraceDiff{Line: 2, A: "x", B: "y", Context: []string{l1}, Fields: []string{"line"}}},
+	}
+	for _, tt := range tests {
+		got, err := DiffTraces(strings.NewReader(tt.a), strings.NewReader(tt.b), 2)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || got != nil && (got.Line != tt.want.Line || got.A != tt.want.A || got.B != tt.want.B ||
+			!slices.Equal(got.Context, tt.want.Context) || !slices.Equal(got.Fields, tt.want.Fields)) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReplayFilteredTrace(t *testing.T) {
+	code := map[uint16][]byte{
+		0x0100: {
+			0x3c,       // inc a
+			0xfe, 0x05, // cp 5
+			0x20, 0xfb, // jr nz, $0100
+			0x18, 0xfe, // jr @
+		},
+	}
+	d := loadTestROM(t, code)
+	d.SetRegister("a", 0)
+	file := filepath.Join(t.TempDir(), "trace.log")
+	if err := d.StartTrace(file, TraceFilter{Start: 0x0101, End: 0x0101, Bank: AnyBank}, false); err != nil {
+		t.Fatal(err)
+	}
+	d.RunFor(20)
+	if err := d.StopTrace(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d = loadTestROM(t, code)
+	d.SetRegister("a", 0)
+	if err := d.ReplayTrace(f, 3); err != nil {
+		t.Fatal(err)
+	}
+	if a, pc := d.Z.CPU.a, d.PC(); a != 3 || pc != 0x0103 {
+		t.Errorf("replayed to a=%d pc=%04x, want a=3 pc=0103 after the third cp", a, pc)
+	}
+}
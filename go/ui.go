@@ -12,10 +12,25 @@ import (
 type CLI struct {
 	Debugger *Debugger
 
-	dasmStartAddr uint16
-	dasmAddrs     []uint16
+	dasmStartAddr BankAddr
+	dasmAddrs     []BankAddr
 	dasmCursor    int
 	memStartAddr  uint16
+	cpuEditing    bool
+	cpuCursor     int
+	memEditing    bool
+	memCursor     uint16
+	memASCII      bool
+	memNibble     bool
+	memRaw        bool
+	callsCursor   int
+	callsAddrs    []BankAddr
+	search        *RAMSearch
+	searchCursor  int
+
+	commands   []command
+	history    []string
+	historyPos int
 
 	g *gocui.Gui
 }
@@ -25,6 +40,11 @@ const (
 	ViewDisassembly = "disassembly"
 	ViewSerial      = "serial"
 	ViewMemory      = "memory"
+	ViewCalls       = "calls"
+	ViewStack       = "stack"
+	ViewSearch      = "search"
+	ViewOutput      = "output"
+	ViewConsole     = "console"
 )
 
 func NewCLI(d *Debugger) (*CLI, error) {
@@ -36,7 +56,7 @@ func NewCLI(d *Debugger) (*CLI, error) {
 	cli := &CLI{
 		Debugger: d,
 
-		dasmStartAddr: 0x0100,
+		dasmStartAddr: BankAddr{Addr: 0x0100},
 		memStartAddr:  0xc000,
 
 		g: g,
@@ -47,8 +67,14 @@ func NewCLI(d *Debugger) (*CLI, error) {
 		gocui.ManagerFunc(cli.RenderDisassembly),
 		gocui.ManagerFunc(cli.RenderSerial),
 		gocui.ManagerFunc(cli.RenderMemory),
+		gocui.ManagerFunc(cli.RenderCalls),
+		gocui.ManagerFunc(cli.RenderStack),
+		gocui.ManagerFunc(cli.RenderSearch),
+		gocui.ManagerFunc(cli.RenderConsole),
 	)
 	go cli.readSerial()
+	cli.initCommands()
+	cli.bindConsole()
 
 	cli.bind('q', func() { cli.g.Update(func(_ *gocui.Gui) error { return gocui.ErrQuit }) })
 	cli.bind('j', func() { cli.dasmCursor++ })
@@ -57,10 +83,49 @@ func NewCLI(d *Debugger) (*CLI, error) {
 	cli.bind(gocui.KeyCtrlY, func() { cli.dasmStartAddr = cli.Debugger.PrevAddr(cli.dasmStartAddr) })
 	cli.bind(gocui.KeyCtrlD, func() { cli.memStartAddr += 0x100 })
 	cli.bind(gocui.KeyCtrlU, func() { cli.memStartAddr -= 0x100 })
-	cli.bind('b', func() { cli.Debugger.ToggleBreakpoint(cli.dasmAddrs[cli.dasmCursor]) })
+	cli.bind('b', func() {
+		if addr, ok := cli.cursorAddr(); ok {
+			cli.Debugger.ToggleBreakpoint(addr)
+		}
+	})
 	cli.bind('i', func() { cli.Debugger.StepInto(); cli.JumpToDasm() })
 	cli.bind('n', func() { cli.Debugger.StepOver(); cli.JumpToDasm() })
 	cli.bind('r', func() { cli.Debugger.Run(); cli.JumpToDasm() })
+	cli.bind('o', func() { cli.Debugger.StepOut(); cli.JumpToDasm() })
+	cli.bind('I', func() { cli.Debugger.StepBack(); cli.JumpToDasm() })
+	cli.bind('R', func() { cli.Debugger.ReverseContinue(); cli.JumpToDasm() })
+	cli.bind('c', func() {
+		if addr, ok := cli.cursorAddr(); ok {
+			cli.Debugger.RunTo(addr)
+			cli.JumpToDasm()
+		}
+	})
+	cli.bind(':', cli.focusConsole)
+	cli.bind('a', func() {
+		cli.focusConsole()
+		if v, err := cli.g.View(ViewConsole); err == nil {
+			line := "asm "
+			if addr, ok := cli.cursorAddr(); ok {
+				line += addr.String() + " "
+			}
+			cli.setConsoleLine(v, line)
+		}
+	})
+	cli.bind('e', func() { cli.cpuEditing = true; cli.g.SetCurrentView(ViewCPU) })
+	cli.bindCPU()
+	cli.bind('f', func() { cli.callsCursor = 0; cli.g.SetCurrentView(ViewCalls) })
+	cli.bindCalls()
+	cli.bind('s', func() {
+		if cli.search != nil {
+			cli.g.SetCurrentView(ViewSearch)
+		}
+	})
+	cli.bindSearch()
+	cli.bind('m', func() {
+		cli.memEditing = true
+		cli.memCursor = max(cli.memCursor, cli.memStartAddr)
+		cli.g.SetCurrentView(ViewMemory)
+	})
 
 	return cli, nil
 }
@@ -78,26 +143,91 @@ func (cli *CLI) RenderCPU(g *gocui.Gui) error {
 
 	z := cli.Debugger.CPUState()
 
-	fmt.Fprintf(v, " b %02X c %02X d %02X e %02X\n"+
-		" h %02X l %02X a %02X f %c%c%c%c\n"+
-		" sp %04X pc %04X %c %cI #%d\n\n",
-		z.B, z.C, z.D, z.E,
-		z.H, z.L, z.A,
-		tern(z.FZ, 'Z', 'z'),
-		tern(z.FN, 'N', 'n'),
-		tern(z.FH, 'H', 'h'),
-		tern(z.FC, 'C', 'c'),
-		z.SP, z.PC,
-		tern(z.Stopped, 'S', tern(z.Halted, 'H', 'R')),
-		tern(z.IrqEnabled, 'E', 'D'),
+	// highlight the field being edited
+	f := func(name string, format string, a any) string {
+		s := fmt.Sprintf(format, a)
+		if cli.cpuEditing && cpuFields[cli.cpuCursor] == name {
+			return "\x1b[37;44m" + s + "\x1b[0m"
+		}
+		return s
+	}
+
+	fmt.Fprintf(v, " b %s c %s d %s e %s\n"+
+		" h %s l %s a %s f %s%s%s%s\n"+
+		" sp %s pc %s %s %sI #%d\n\n",
+		f("b", "%02X", z.B), f("c", "%02X", z.C), f("d", "%02X", z.D), f("e", "%02X", z.E),
+		f("h", "%02X", z.H), f("l", "%02X", z.L), f("a", "%02X", z.A),
+		f("fz", "%c", tern(z.FZ, 'Z', 'z')),
+		f("fn", "%c", tern(z.FN, 'N', 'n')),
+		f("fh", "%c", tern(z.FH, 'H', 'h')),
+		f("fc", "%c", tern(z.FC, 'C', 'c')),
+		f("sp", "%04X", z.SP), f("pc", "%04X", z.PC),
+		f("halted", "%c", tern(z.Stopped, 'S', tern(z.Halted, 'H', 'R'))),
+		f("ime", "%c", tern(z.IrqEnabled, 'E', 'D')),
 		z.Cycles,
 	)
 
 	return nil
 }
 
+// cpuFields are the editable fields of the cpu view in display order.
+var cpuFields = []string{"b", "c", "d", "e", "h", "l", "a", "fz", "fn", "fh", "fc", "sp", "pc", "halted", "ime"}
+
+// cpuFlags are the fields set with Debugger.SetFlag.
+var cpuFlags = []string{"fz", "fn", "fh", "fc", "halted", "stopped", "ime"}
+
+func (cli *CLI) bindCPU() {
+	leave := func(_ *gocui.View) { cli.cpuEditing = false; cli.g.SetCurrentView(ViewDisassembly) }
+	move := func(n int) func(_ *gocui.View) {
+		return func(_ *gocui.View) {
+			cli.cpuCursor = (cli.cpuCursor + n + len(cpuFields)) % len(cpuFields)
+		}
+	}
+	add := func(n int) func(_ *gocui.View) {
+		return func(_ *gocui.View) {
+			name := cpuFields[cli.cpuCursor]
+			if slices.Contains(cpuFlags, name) {
+				return
+			}
+			mask := tern(len(name) == 2, 0xffff, 0xff)
+			cli.Debugger.SetRegister(name, (exprIdents[name](cli.Debugger)+n)&mask)
+		}
+	}
+
+	cli.bindView(ViewCPU, gocui.KeyEsc, leave)
+	cli.bindView(ViewCPU, 'e', leave)
+	cli.bindView(ViewCPU, gocui.KeyArrowLeft, move(-1))
+	cli.bindView(ViewCPU, 'h', move(-1))
+	cli.bindView(ViewCPU, gocui.KeyArrowRight, move(1))
+	cli.bindView(ViewCPU, 'l', move(1))
+	cli.bindView(ViewCPU, '+', add(1))
+	cli.bindView(ViewCPU, '-', add(-1))
+	cli.bindView(ViewCPU, gocui.KeySpace, func(_ *gocui.View) {
+		name := cpuFields[cli.cpuCursor]
+		if slices.Contains(cpuFlags, name) {
+			cli.Debugger.SetFlag(name, exprIdents[name](cli.Debugger) == 0)
+		}
+	})
+	cli.bindView(ViewCPU, gocui.KeyEnter, func(_ *gocui.View) {
+		leave(nil)
+		cli.focusConsole()
+		if v, err := cli.g.View(ViewConsole); err == nil {
+			cli.setConsoleLine(v, "set "+cpuFields[cli.cpuCursor]+"=")
+		}
+	})
+}
+
+// cursorAddr returns the address on the disassembly cursor's row, or false
+// before the disassembly has been drawn.
+func (cli *CLI) cursorAddr() (BankAddr, bool) {
+	if cli.dasmCursor < 0 || cli.dasmCursor >= len(cli.dasmAddrs) {
+		return BankAddr{}, false
+	}
+	return cli.dasmAddrs[cli.dasmCursor], true
+}
+
 func (cli *CLI) JumpToDasm() {
-	pc := cli.Debugger.PC()
+	pc := cli.Debugger.BankAddr(cli.Debugger.PC())
 	if !slices.Contains(cli.dasmAddrs, pc) {
 		cli.dasmStartAddr = pc
 	}
@@ -111,24 +241,38 @@ func (cli *CLI) RenderDisassembly(g *gocui.Gui) error {
 		}
 		_, maxY := g.Size()
 		v, _ = g.SetView(ViewDisassembly, 0, 5, 35, maxY-10)
-		v.Title = ViewDisassembly
 	}
 	v.Clear()
+	v.Title = ViewDisassembly
+	if w := cli.Debugger.LastWatch(); w != nil {
+		v.Title = w.String()
+	}
 	_, maxY := v.Size()
 
-	pc := cli.Debugger.PC()
+	pc := cli.Debugger.BankAddr(cli.Debugger.PC())
 
 	// check bounds
-	cli.dasmCursor = max(0, min(maxY, cli.dasmCursor))
 	if len(cli.dasmAddrs) != maxY {
-		cli.dasmAddrs = make([]uint16, maxY)
+		cli.dasmAddrs = make([]BankAddr, maxY)
 	}
+	cli.dasmCursor = max(0, min(len(cli.dasmAddrs)-1, cli.dasmCursor))
 
+	// never start in the middle of an instruction
+	cli.dasmStartAddr = cli.Debugger.InstructionStart(cli.dasmStartAddr)
 	addr := cli.dasmStartAddr
+	header := true
 	for i := 0; i < maxY; i++ {
 		dasm := cli.Debugger.Disassemble(addr)
 		cli.dasmAddrs[i] = addr
 
+		// labels get their own row before the instruction
+		if dasm.Label != "" && header {
+			fmt.Fprintf(v, "%s%s:\n\x1b[0m", tern(i == cli.dasmCursor, "\x1b[37;44m", ""), dasm.Label)
+			header = false
+			continue
+		}
+		header = true
+
 		var c byte = tern[byte](addr == pc, '>', ' ')
 
 		color := ""
@@ -138,11 +282,13 @@ func (cli *CLI) RenderDisassembly(g *gocui.Gui) error {
 			color = "\x1b[37;42m"
 		} else if cli.Debugger.IsBreakpoint(addr) {
 			color = "\x1b[37;41m"
+		} else if cli.Debugger.IsPatched(addr) {
+			color = "\x1b[33m"
 		}
 
 		fmt.Fprintf(v, "%s%c %s                     \n\x1b[0m", color, c, dasm.String())
 
-		addr += uint16(len(dasm.Bytes))
+		addr = cli.Debugger.Offset(addr, len(dasm.Bytes))
 	}
 
 	return nil
@@ -163,6 +309,156 @@ func (cli *CLI) RenderSerial(g *gocui.Gui) error {
 	return nil
 }
 
+// RenderCalls shows the call stack innermost first, starting with the
+// current pc. Each row is the address execution is at in that frame.
+func (cli *CLI) RenderCalls(g *gocui.Gui) error {
+	v, err := g.View(ViewCalls)
+	if err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		maxX, maxY := g.Size()
+		v, _ = g.SetView(ViewCalls, 36, maxY-17, 36+(maxX-36)/2, maxY-9)
+		v.Title = ViewCalls
+	}
+	v.Clear()
+
+	d := cli.Debugger
+	frames := d.CallStack()
+	cli.callsAddrs = append(cli.callsAddrs[:0], d.BankAddr(d.PC()))
+	for i := len(frames) - 1; i >= 0; i-- {
+		cli.callsAddrs = append(cli.callsAddrs, frames[i].From)
+	}
+	cli.callsCursor = max(0, min(len(cli.callsAddrs)-1, cli.callsCursor))
+
+	// scroll to keep the cursor visible
+	_, maxY := v.Size()
+	v.SetOrigin(0, max(0, cli.callsCursor-maxY+1))
+
+	focused := g.CurrentView() == v
+	for i, addr := range cli.callsAddrs {
+		// frame i is executing in the function entered by frame i+1
+		fn, irq := "", false
+		if n := len(frames) - i; n > 0 {
+			fn = d.Describe(frames[n-1].To)
+		}
+		if i > 0 {
+			irq = frames[len(frames)-i].Interrupt
+		}
+		color := tern(focused && i == cli.callsCursor, "\x1b[37;44m", "")
+		fmt.Fprintf(v, "%s#%-2d %-7s %s%s\n\x1b[0m", color, i, addr, fn, tern(irq, " [irq]", ""))
+	}
+	return nil
+}
+
+func (cli *CLI) RenderStack(g *gocui.Gui) error {
+	v, err := g.View(ViewStack)
+	if err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		maxX, maxY := g.Size()
+		v, _ = g.SetView(ViewStack, 36+(maxX-36)/2+1, maxY-17, maxX-1, maxY-9)
+		v.Title = ViewStack
+	}
+	v.Clear()
+	_, maxY := v.Size()
+
+	for _, w := range cli.Debugger.Stack(maxY) {
+		fmt.Fprintf(v, "%04X %04X %s\n", w.Addr, w.Value, w.Note)
+	}
+	return nil
+}
+
+func (cli *CLI) bindCalls() {
+	leave := func(_ *gocui.View) { cli.g.SetCurrentView(ViewDisassembly) }
+	move := func(n int) func(_ *gocui.View) {
+		return func(_ *gocui.View) { cli.callsCursor += n }
+	}
+
+	cli.bindView(ViewCalls, gocui.KeyEsc, leave)
+	cli.bindView(ViewCalls, 'f', leave)
+	cli.bindView(ViewCalls, gocui.KeyArrowUp, move(-1))
+	cli.bindView(ViewCalls, 'k', move(-1))
+	cli.bindView(ViewCalls, gocui.KeyArrowDown, move(1))
+	cli.bindView(ViewCalls, 'j', move(1))
+	cli.bindView(ViewCalls, gocui.KeyEnter, func(_ *gocui.View) {
+		if cli.callsCursor < len(cli.callsAddrs) {
+			cli.dasmStartAddr = cli.callsAddrs[cli.callsCursor]
+			cli.dasmCursor = 0
+		}
+		leave(nil)
+	})
+}
+
+// RenderSearch shows the candidates of a ram search over the stack while one
+// is running.
+func (cli *CLI) RenderSearch(g *gocui.Gui) error {
+	if cli.search == nil {
+		if err := g.DeleteView(ViewSearch); err != nil && err != gocui.ErrUnknownView {
+			return err
+		}
+		return nil
+	}
+	maxX, maxY := g.Size()
+	v, err := g.SetView(ViewSearch, 36+(maxX-36)/2+1, maxY-17, maxX-1, maxY-9)
+	if err != nil && err != gocui.ErrUnknownView {
+		return err
+	}
+	g.SetViewOnTop(ViewSearch)
+	v.Clear()
+	v.Title = "search: " + cli.search.String()
+
+	cands := cli.search.Candidates()
+	cli.searchCursor = max(0, min(len(cands)-1, cli.searchCursor))
+
+	// only write the rows on screen, there may be thousands
+	_, rows := v.Size()
+	start := max(0, cli.searchCursor-rows+1)
+	focused := g.CurrentView() == v
+	for i := start; i < min(len(cands), start+rows); i++ {
+		c := cands[i]
+		color := tern(focused && i == cli.searchCursor, "\x1b[37;44m", "")
+		name, _ := cli.Debugger.symbols.Name(cli.Debugger.BankAddr(c.Addr))
+		fmt.Fprintf(v, "%s%04X %5d -> %-5d %s\n\x1b[0m", color, c.Addr, c.Prev, c.Value, name)
+	}
+	return nil
+}
+
+func (cli *CLI) bindSearch() {
+	leave := func(_ *gocui.View) { cli.g.SetCurrentView(ViewDisassembly) }
+	move := func(n int) func(_ *gocui.View) {
+		return func(_ *gocui.View) { cli.searchCursor += n }
+	}
+	selected := func() (SearchCandidate, bool) {
+		if cli.search == nil || cli.searchCursor >= len(cli.search.Candidates()) {
+			return SearchCandidate{}, false
+		}
+		return cli.search.Candidates()[cli.searchCursor], true
+	}
+
+	cli.bindView(ViewSearch, gocui.KeyEsc, leave)
+	cli.bindView(ViewSearch, 's', leave)
+	cli.bindView(ViewSearch, gocui.KeyArrowUp, move(-1))
+	cli.bindView(ViewSearch, 'k', move(-1))
+	cli.bindView(ViewSearch, gocui.KeyArrowDown, move(1))
+	cli.bindView(ViewSearch, 'j', move(1))
+	cli.bindView(ViewSearch, gocui.KeyCtrlU, move(-0x10))
+	cli.bindView(ViewSearch, gocui.KeyCtrlD, move(0x10))
+	// watch for the code that writes it
+	cli.bindView(ViewSearch, 'w', func(_ *gocui.View) {
+		if c, ok := selected(); ok {
+			cli.Debugger.AddWatchpoint(c.Addr, c.Addr+uint16(cli.search.Size-1), WatchWrite, AnyValue)
+			cli.printf("watching %04X", c.Addr)
+		}
+	})
+	cli.bindView(ViewSearch, gocui.KeyEnter, func(_ *gocui.View) {
+		if c, ok := selected(); ok {
+			cli.memStartAddr = c.Addr & 0xfff0
+		}
+	})
+}
+
 func (cli *CLI) readSerial() {
 	for {
 		b := <-cli.Debugger.Z.Serial
@@ -184,12 +480,27 @@ func (cli *CLI) RenderMemory(g *gocui.Gui) error {
 			return err
 		}
 		maxX, maxY := g.Size()
-		v, _ = g.SetView(ViewMemory, 36, 0, maxX-1, maxY-1)
-		v.Title = ViewMemory
+		v, _ = g.SetView(ViewMemory, 36, 0, maxX-1, maxY-18)
+		v.Editable = true
+		v.Editor = gocui.EditorFunc(cli.editMemory)
 	}
 	v.Clear()
+	v.Title = ViewMemory
+	if cli.memEditing {
+		v.Title += tern(cli.memRaw, " [raw]", " [cpu]")
+	}
 	_, maxY := v.Size()
 
+	// keep the cursor on screen
+	if cli.memEditing {
+		row := cli.memCursor & 0xfff0
+		if row < cli.memStartAddr {
+			cli.memStartAddr = row
+		} else if int(row-cli.memStartAddr) >= maxY*0x10 {
+			cli.memStartAddr = row - uint16((maxY-1)*0x10)
+		}
+	}
+
 	cli.memStartAddr &= 0xfff0
 	maxAddr := cli.memStartAddr + uint16(maxY*0x10)
 	if maxAddr < cli.memStartAddr { // wrapped
@@ -197,24 +508,35 @@ func (cli *CLI) RenderMemory(g *gocui.Gui) error {
 	}
 
 	for y := cli.memStartAddr; y < maxAddr; y += 0x10 {
-		fmt.Fprintf(v, "%04X ", y)
+		fmt.Fprintf(v, "%-7s ", cli.Debugger.BankAddr(y))
 
 		var sb strings.Builder
 		for j := uint16(0); j < 0x10; j++ {
 			addr := y + j
 			b := cli.Debugger.Read(addr)
-
-			if addr == cli.Debugger.PC() {
-				fmt.Fprintf(v, "\x1b[37;42m%02X\x1b[0m ", b)
-			} else {
-				fmt.Fprintf(v, "%02X ", b)
+			cursor := cli.memEditing && addr == cli.memCursor
+
+			color := ""
+			if cursor && !cli.memASCII {
+				color = "\x1b[37;44m"
+			} else if addr == cli.Debugger.PC() {
+				color = "\x1b[37;42m"
+			} else if cli.Debugger.Changed(addr) {
+				color = "\x1b[31m"
 			}
+			fmt.Fprintf(v, "%s%02X\x1b[0m ", color, b)
 
+			if cursor && cli.memASCII {
+				fmt.Fprintf(&sb, "\x1b[37;44m")
+			}
 			if b >= 0x20 && b < 0x80 {
 				fmt.Fprintf(&sb, "%c", b)
 			} else {
 				fmt.Fprintf(&sb, ".")
 			}
+			if cursor && cli.memASCII {
+				fmt.Fprintf(&sb, "\x1b[0m")
+			}
 		}
 		fmt.Fprintf(v, "%s\n", sb.String())
 
@@ -226,13 +548,88 @@ func (cli *CLI) RenderMemory(g *gocui.Gui) error {
 	return nil
 }
 
+func (cli *CLI) editMemory(v *gocui.View, key gocui.Key, ch rune, mod gocui.Modifier) {
+	d := cli.Debugger
+	write := func(b byte) {
+		d.Write(d.BankAddr(cli.memCursor), b, tern(cli.memRaw, WriteRaw, WriteCPU))
+	}
+	move := func(n int) {
+		cli.memCursor += uint16(n)
+		cli.memNibble = false
+	}
+
+	switch {
+	case key == gocui.KeyEsc || key == gocui.KeyCtrlC || (!cli.memASCII && ch == 'm'):
+		cli.memEditing = false
+		cli.g.SetCurrentView(ViewDisassembly)
+	case key == gocui.KeyArrowLeft || (!cli.memASCII && ch == 'h'):
+		move(-1)
+	case key == gocui.KeyArrowRight || (!cli.memASCII && ch == 'l'):
+		move(1)
+	case key == gocui.KeyArrowUp || (!cli.memASCII && ch == 'k'):
+		move(-0x10)
+	case key == gocui.KeyArrowDown || (!cli.memASCII && ch == 'j'):
+		move(0x10)
+	case key == gocui.KeyCtrlU:
+		move(-0x100)
+	case key == gocui.KeyCtrlD:
+		move(0x100)
+	case key == gocui.KeyTab:
+		cli.memASCII = !cli.memASCII
+		cli.memNibble = false
+	case key == gocui.KeyCtrlW:
+		cli.memRaw = !cli.memRaw
+	case key == gocui.KeyCtrlG || (!cli.memASCII && ch == 'g'):
+		cli.focusConsole()
+		if v, err := cli.g.View(ViewConsole); err == nil {
+			cli.setConsoleLine(v, "mem ")
+		}
+	case cli.memASCII && key == gocui.KeySpace:
+		write(' ')
+		move(1)
+	case cli.memASCII && ch >= 0x20 && ch < 0x7f:
+		write(byte(ch))
+		move(1)
+	case !cli.memASCII && strings.ContainsRune("0123456789abcdefABCDEF", ch) && ch != 0:
+		n, _ := parseHex(string(ch), 8)
+		b := d.Read(cli.memCursor)
+		if !cli.memNibble {
+			write(b&0x0f | byte(n)<<4)
+			cli.memNibble = true
+		} else {
+			write(b&0xf0 | byte(n))
+			move(1)
+		}
+	}
+}
+
 func (cli *CLI) bind(key any, handler func()) {
-	cli.g.SetKeybinding("", key, gocui.ModNone, func(_ *gocui.Gui, _ *gocui.View) error {
+	cli.g.SetKeybinding("", key, gocui.ModNone, func(_ *gocui.Gui, v *gocui.View) error {
+		// global bindings also match while typing in the console or editing
+		// the cpu or memory view, so pass keys on to the view's editor
+		if v != nil && slices.Contains([]string{ViewConsole, ViewCPU, ViewMemory, ViewCalls, ViewSearch}, v.Name()) {
+			if v.Editable {
+				switch k := key.(type) {
+				case rune:
+					v.Editor.Edit(v, 0, k, gocui.ModNone)
+				case gocui.Key:
+					v.Editor.Edit(v, k, 0, gocui.ModNone)
+				}
+			}
+			return nil
+		}
 		handler()
 		return nil
 	})
 }
 
+func (cli *CLI) bindView(view string, key any, handler func(v *gocui.View)) {
+	cli.g.SetKeybinding(view, key, gocui.ModNone, func(_ *gocui.Gui, v *gocui.View) error {
+		handler(v)
+		return nil
+	})
+}
+
 func (cli *CLI) MainLoop() error {
 	return cli.g.MainLoop()
 }
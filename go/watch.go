@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// #include "../build/libcgoboy.h"
+import "C"
+
+type WatchKind int
+
+const (
+	WatchRead   WatchKind = C.WATCH_READ
+	WatchWrite  WatchKind = C.WATCH_WRITE
+	WatchChange WatchKind = C.WATCH_CHANGE
+)
+
+// AnyValue matches any new value for WatchChange watchpoints.
+const AnyValue = -1
+
+// Watchpoint breaks on CPU accesses to the addresses Start-End (inclusive).
+// WatchChange watchpoints break on writes that change the stored byte and, if
+// Value is not AnyValue, only when the new byte is Value.
+type Watchpoint struct {
+	Start, End uint16
+	Kind       WatchKind
+	Value      int
+}
+
+func (w *Watchpoint) String() string {
+	var s string
+	if w.Start == w.End {
+		s = fmt.Sprintf("%04X", w.Start)
+	} else {
+		s = fmt.Sprintf("%04X-%04X", w.Start, w.End)
+	}
+	switch w.Kind {
+	case WatchRead:
+		return s + " read"
+	case WatchWrite:
+		return s + " write"
+	}
+	if w.Value != AnyValue {
+		return s + fmt.Sprintf(" change to %02X", w.Value)
+	}
+	return s + " change"
+}
+
+// WatchHit describes the access that triggered a watchpoint. Dasm is the
+// instruction that made the access.
+type WatchHit struct {
+	Watch    *Watchpoint
+	Kind     WatchKind
+	Addr     uint16
+	Old, New uint8
+	Dasm     Dasm
+}
+
+func (h *WatchHit) String() string {
+	if h.Kind == WatchRead {
+		return fmt.Sprintf("R %04X=%02X @%s %s", h.Addr, h.New, h.Dasm.Addr, h.Dasm.Decoded)
+	}
+	return fmt.Sprintf("W %04X=%02X @%s %s", h.Addr, h.New, h.Dasm.Addr, h.Dasm.Decoded)
+}
+
+func (d *Debugger) AddWatchpoint(start, end uint16, kind WatchKind, value int) {
+	d.watchpoints = append(d.watchpoints, &Watchpoint{
+		Start: start,
+		End:   max(start, end),
+		Kind:  kind,
+		Value: value,
+	})
+	d.armWatchpoints()
+}
+
+func (d *Debugger) RemoveWatchpoint(w *Watchpoint) {
+	d.watchpoints = slices.DeleteFunc(d.watchpoints, func(x *Watchpoint) bool { return x == w })
+	d.armWatchpoints()
+}
+
+func (d *Debugger) Watchpoints() []*Watchpoint {
+	return d.watchpoints
+}
+
+// LastWatch returns the access that stopped the last run, or nil if it was
+// not stopped by a watchpoint.
+func (d *Debugger) LastWatch() *WatchHit {
+	return d.lastWatch
+}
+
+// armWatchpoints rebuilds the per-address flags checked by the core. The core
+// skips all checks when nothing is watched.
+func (d *Debugger) armWatchpoints() {
+	if len(d.watchpoints) == 0 {
+		d.Z.CPU.watch = nil
+		return
+	}
+
+	if d.watchFlags == nil {
+		d.watchPtr, d.watchFlags = malloc[C.uchar](0x10000)
+	}
+	clear(d.watchFlags)
+	for _, w := range d.watchpoints {
+		for addr := int(w.Start); addr <= int(w.End); addr++ {
+			d.watchFlags[addr] |= C.uchar(w.Kind)
+		}
+	}
+	d.Z.CPU.watch = d.watchPtr
+}
+
+// checkWatch is called after every step with the PC of the instruction that
+// was executed. The first access that matches a watchpoint stops the run.
+func (d *Debugger) checkWatch(pc BankAddr) bool {
+	z := &d.Z.CPU
+	for _, hit := range z.watch_hits[:z.watch_len] {
+		addr := uint16(hit.addr)
+		old, val := uint8(hit.old), uint8(hit.value)
+		for _, w := range d.watchpoints {
+			if addr < w.Start || addr > w.End {
+				continue
+			}
+
+			var kind WatchKind
+			switch {
+			case hit.kind == C.WATCH_READ && w.Kind == WatchRead:
+				kind = WatchRead
+			case hit.kind == C.WATCH_WRITE && w.Kind == WatchWrite:
+				kind = WatchWrite
+			case hit.kind == C.WATCH_WRITE && w.Kind == WatchChange &&
+				old != val && (w.Value == AnyValue || w.Value == int(val)):
+				kind = WatchChange
+			default:
+				continue
+			}
+
+			d.lastWatch = &WatchHit{
+				Watch: w,
+				Kind:  kind,
+				Addr:  addr,
+				Old:   old,
+				New:   val,
+				Dasm:  d.Disassemble(pc),
+			}
+			return true
+		}
+	}
+	return false
+}
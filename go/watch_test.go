@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestWatchpoints(t *testing.T) {
+	d := loadTestROM(t, map[uint16][]byte{
+		0x0100: {
+			0x31, 0x02, 0xc1, // ld sp, $c102
+			0x01, 0x05, 0x01, // ld bc, $0105
+			0xc5,       // push bc
+			0x18, 0xfe, // jr @
+		},
+	})
+	// executing code doesn't count as reading it
+	d.AddWatchpoint(0x0100, 0x01ff, WatchRead, AnyValue)
+	// push writes b to c101 before c to c100
+	d.AddWatchpoint(0xc100, 0xc101, WatchChange, 0x05)
+
+	for i := 0; i < 4 && d.LastWatch() == nil; i++ {
+		d.StepInto()
+	}
+	hit := d.LastWatch()
+	if hit == nil {
+		t.Fatal("no watchpoint hit")
+	}
+	if hit.Kind != WatchChange || hit.Addr != 0xc100 || hit.New != 0x05 || hit.Dasm.Addr.Addr != 0x0106 {
+		t.Errorf("got hit %s, want c100=05 by the push at 0106", hit)
+	}
+}